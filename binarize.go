@@ -0,0 +1,240 @@
+package Netpbm
+
+import "math"
+
+// ThresholdMethod selects how Binarize chooses the gray value below which a
+// pixel becomes black.
+type ThresholdMethod int
+
+const (
+	// ThresholdFixed uses BinarizeOptions.Level everywhere.
+	ThresholdFixed ThresholdMethod = iota
+	// ThresholdOtsu picks the global level that best separates the
+	// histogram into two classes, by Otsu's method.
+	ThresholdOtsu
+	// ThresholdMean and ThresholdGaussian use the mean, plain or Gaussian
+	// weighted, of the window around each pixel minus Offset.
+	ThresholdMean
+	ThresholdGaussian
+	// ThresholdNiblack uses m + k*s, with m and s the mean and standard
+	// deviation of the window around each pixel.
+	ThresholdNiblack
+	// ThresholdSauvola uses m * (1 + k*(s/R - 1)).
+	ThresholdSauvola
+)
+
+// BinarizeOptions controls Binarize. A nil *BinarizeOptions, like the zero
+// value, thresholds at half the maxval.
+//
+// Levels, offsets and R are fractions of the maxval, so that they mean the
+// same thing whatever the maxval of the image.
+type BinarizeOptions struct {
+	Method ThresholdMethod
+	// Level is the threshold of ThresholdFixed. Zero means 0.5.
+	Level float64
+	// Window is the side, in pixels, of the square window of the local
+	// methods. Zero means 15; even sizes are rounded up to the next odd one.
+	// Windows are clipped at the image edges.
+	Window int
+	// Offset is subtracted from the local mean of ThresholdMean and
+	// ThresholdGaussian.
+	Offset float64
+	// K is k of Niblack and Sauvola. Zero means -0.2 for Niblack and 0.5
+	// for Sauvola.
+	K float64
+	// R is the dynamic range of the standard deviation for Sauvola. Zero
+	// means 0.5.
+	R float64
+	// Gray selects how colors become gray; nil averages the channels.
+	Gray *ConvertOptions
+}
+
+// Binarize converts a PBM, PGM or PPM image to a PBM, making black the
+// pixels whose gray value is below the threshold chosen by the options. The
+// result has its origin at (0, 0) and keeps the source's flavor.
+func (o *BinarizeOptions) Binarize(img PNM) *PBM {
+	var opts BinarizeOptions
+	if o != nil {
+		opts = *o
+	}
+	pgm := opts.Gray.ToPGM(img)
+	width, height := pgm.Size()
+	maxValue := float64(pgm.max)
+	pbm := NewPBM(width, height)
+	pbm.magicNumber = sameFlavor(pgm.magicNumber, "P1", "P4")
+
+	var threshold func(x, y int) float64
+	switch opts.Method {
+	case ThresholdOtsu:
+		level := float64(otsu(pgm)) + 1
+		threshold = func(x, y int) float64 { return level }
+	case ThresholdMean, ThresholdNiblack, ThresholdSauvola:
+		windows := newIntegral(pgm)
+		size := opts.window()
+		k, r := opts.K, opts.R
+		if k == 0 {
+			k = 0.5
+			if opts.Method == ThresholdNiblack {
+				k = -0.2
+			}
+		}
+		if r == 0 {
+			r = 0.5
+		}
+		threshold = func(x, y int) float64 {
+			mean, deviation := windows.stats(x, y, size)
+			switch opts.Method {
+			case ThresholdNiblack:
+				return mean + k*deviation
+			case ThresholdSauvola:
+				return mean * (1 + k*(deviation/(r*maxValue)-1))
+			}
+			return mean - opts.Offset*maxValue
+		}
+	case ThresholdGaussian:
+		blurred := gaussianBlur(pgm, opts.window())
+		threshold = func(x, y int) float64 {
+			return blurred[y*width+x] - opts.Offset*maxValue
+		}
+	default:
+		level := opts.Level
+		if level == 0 {
+			level = 0.5
+		}
+		level *= maxValue
+		threshold = func(x, y int) float64 { return level }
+	}
+
+	// The local thresholds carry float rounding error, which would turn
+	// pixels equal to their threshold, such as those of a flat area, black.
+	tolerance := 1e-9 * maxValue
+	pgm.Each(func(x, y int, value uint16) {
+		pbm.Set(x, y, float64(value) < threshold(x, y)-tolerance)
+	})
+	return pbm
+}
+
+func (o *BinarizeOptions) window() int {
+	if o.Window <= 0 {
+		return 15
+	}
+	return o.Window | 1
+}
+
+// otsu returns the gray value that maximizes the between-class variance
+// when the values up to it form one class and the others the second.
+func otsu(pgm *PGM) int {
+	histogram := make([]int, int(pgm.max)+1)
+	pgm.Each(func(_, _ int, value uint16) {
+		histogram[value]++
+	})
+
+	total, sum := 0, 0.0
+	for value, count := range histogram {
+		total += count
+		sum += float64(value) * float64(count)
+	}
+
+	best, level := -1.0, 0
+	count0, sum0 := 0, 0.0
+	for value, count := range histogram {
+		count0 += count
+		sum0 += float64(value) * float64(count)
+		count1 := total - count0
+		if count0 == 0 {
+			continue
+		}
+		if count1 == 0 {
+			break
+		}
+		difference := sum0/float64(count0) - (sum-sum0)/float64(count1)
+		if between := float64(count0) * float64(count1) * difference * difference; between > best {
+			best, level = between, value
+		}
+	}
+	return level
+}
+
+// integral holds summed-area tables of the values of a PGM and of their
+// squares, with a zero row and column in front.
+type integral struct {
+	sums, squares []uint64
+	width, height int
+}
+
+func newIntegral(pgm *PGM) integral {
+	width, height := pgm.Size()
+	in := integral{
+		sums:    make([]uint64, (width+1)*(height+1)),
+		squares: make([]uint64, (width+1)*(height+1)),
+		width:   width,
+		height:  height,
+	}
+	stride := width + 1
+	for y := 0; y < height; y++ {
+		var rowSum, rowSquares uint64
+		for x := 0; x < width; x++ {
+			value := uint64(pgm.GrayAt(x, y))
+			rowSum += value
+			rowSquares += value * value
+			i := (y+1)*stride + x + 1
+			in.sums[i] = in.sums[i-stride] + rowSum
+			in.squares[i] = in.squares[i-stride] + rowSquares
+		}
+	}
+	return in
+}
+
+// stats returns the mean and standard deviation of the values in the
+// size x size window centred on (x, y), clipped to the image.
+func (in integral) stats(x, y, size int) (mean, deviation float64) {
+	x0, y0 := clamp(x-size/2, 0, in.width), clamp(y-size/2, 0, in.height)
+	x1, y1 := clamp(x+size/2+1, 0, in.width), clamp(y+size/2+1, 0, in.height)
+	stride := in.width + 1
+	area := func(table []uint64) uint64 {
+		return table[y1*stride+x1] - table[y0*stride+x1] - table[y1*stride+x0] + table[y0*stride+x0]
+	}
+	count := float64((x1 - x0) * (y1 - y0))
+	mean = float64(area(in.sums)) / count
+	variance := float64(area(in.squares))/count - mean*mean
+	return mean, math.Sqrt(math.Max(variance, 0))
+}
+
+// gaussianBlur returns the values of pgm, row by row, blurred by a size x
+// size Gaussian kernel. Weights falling outside the image are left out and
+// the others renormalized.
+func gaussianBlur(pgm *PGM, size int) []float64 {
+	width, height := pgm.Size()
+	radius := size / 2
+	sigma := 0.3*(float64(radius)-1) + 0.8
+	kernel := make([]float64, size)
+	for i := range kernel {
+		d := float64(i - radius)
+		kernel[i] = math.Exp(-d * d / (2 * sigma * sigma))
+	}
+
+	convolve := func(get func(i int) float64, n, i int) float64 {
+		var sum, weight float64
+		for j, w := range kernel {
+			if k := i + j - radius; k >= 0 && k < n {
+				sum += w * get(k)
+				weight += w
+			}
+		}
+		return sum / weight
+	}
+
+	rows := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			rows[y*width+x] = convolve(func(k int) float64 { return float64(pgm.GrayAt(k, y)) }, width, x)
+		}
+	}
+	blurred := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			blurred[y*width+x] = convolve(func(k int) float64 { return rows[k*width+x] }, height, y)
+		}
+	}
+	return blurred
+}
@@ -0,0 +1,41 @@
+package Netpbm
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestBinarizeFlat checks that the local methods leave a flat image white:
+// every pixel equals its local mean, so none is below its threshold.
+func TestBinarizeFlat(t *testing.T) {
+	for _, method := range []struct {
+		name   string
+		method ThresholdMethod
+	}{
+		{"Mean", ThresholdMean},
+		{"Gaussian", ThresholdGaussian},
+		{"Niblack", ThresholdNiblack},
+		{"Sauvola", ThresholdSauvola},
+	} {
+		for _, flat := range []struct{ value, maxValue uint16 }{
+			{0, 255}, {1, 255}, {200, 255}, {251, 255}, {255, 255}, {40000, 65535}, {65535, 65535},
+		} {
+			for _, window := range []int{3, 5, 15, 41} {
+				t.Run(fmt.Sprintf("%s-%d-%d-%d", method.name, flat.value, flat.maxValue, window), func(t *testing.T) {
+					pgm := NewPGM(40, 40, flat.maxValue)
+					pgm.Map(func(uint16) uint16 { return flat.value })
+					pbm := (&BinarizeOptions{Method: method.method, Window: window}).Binarize(pgm)
+					black := 0
+					pbm.Each(func(x, y int, value bool) {
+						if value {
+							black++
+						}
+					})
+					if black != 0 {
+						t.Errorf("%d of 1600 pixels black", black)
+					}
+				})
+			}
+		}
+	}
+}
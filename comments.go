@@ -0,0 +1,88 @@
+package Netpbm
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// comments holds the '#' comment lines of an image header. It is embedded
+// in PBM, PGM and PPM so that comments survive a read/save round trip.
+type comments struct {
+	lines []string
+}
+
+func (c *comments) Comments() []string {
+	return append([]string(nil), c.lines...)
+}
+
+// AddComment appends a comment to the header. A comment spanning several
+// lines is stored as one comment per line.
+func (c *comments) AddComment(comment string) {
+	c.lines = append(c.lines, splitLines(comment)...)
+}
+
+func (c *comments) ClearComments() {
+	c.lines = nil
+}
+
+// Metadata returns the key=value pairs found in the comments.
+func (c *comments) Metadata() map[string]string {
+	metadata := make(map[string]string)
+	for _, line := range c.lines {
+		if key, value, ok := parseMetadata(line); ok {
+			metadata[key] = value
+		}
+	}
+	return metadata
+}
+
+// SetMetadata stores a key=value comment, replacing any earlier comment
+// for the same key. The key must be non-empty and contain neither
+// whitespace nor '='; line breaks in the value become spaces.
+func (c *comments) SetMetadata(key, value string) error {
+	if key == "" || strings.ContainsFunc(key, func(r rune) bool {
+		return r == '=' || r < utf8.RuneSelf && isSpace(byte(r))
+	}) {
+		return fmt.Errorf("invalid metadata key %q", key)
+	}
+	line := key + "=" + strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+	for i, existing := range c.lines {
+		if k, _, ok := parseMetadata(existing); ok && k == key {
+			c.lines[i] = line
+			return nil
+		}
+	}
+	c.lines = append(c.lines, line)
+	return nil
+}
+
+func parseMetadata(line string) (string, string, bool) {
+	key, value, ok := strings.Cut(line, "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" || strings.ContainsAny(key, " \t") {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(value), true
+}
+
+// splitLines splits s at CR, LF and CRLF line breaks.
+func splitLines(s string) []string {
+	s = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(s)
+	return strings.Split(s, "\n")
+}
+
+// writeComments writes one '#' line per line of each comment, so that no
+// comment can end the comment line early.
+func writeComments(writer *bufio.Writer, comments []string) {
+	for _, comment := range comments {
+		for _, line := range splitLines(comment) {
+			if line == "" {
+				writer.WriteString("#\n")
+			} else {
+				writer.WriteString("# " + line + "\n")
+			}
+		}
+	}
+}
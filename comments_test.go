@@ -0,0 +1,31 @@
+package Netpbm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSetMetadata(t *testing.T) {
+	pgm := NewPGM(1, 1, 255)
+	for _, key := range []string{"", "a\n1 1", "a b", "a=b", "a\tb", "a\rb"} {
+		if err := pgm.SetMetadata(key, "x"); err == nil {
+			t.Errorf("key %q accepted", key)
+		}
+	}
+	if err := pgm.SetMetadata("author", "line one\nline two"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := pgm.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodePGM(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := decoded.Metadata()
+	if len(metadata) != 1 || metadata["author"] != "line one line two" {
+		t.Errorf("got metadata %q", metadata)
+	}
+}
@@ -0,0 +1,259 @@
+package Netpbm
+
+import (
+	"image/color"
+	"math"
+)
+
+// ConvertOptions controls conversions between PBM, PGM and PPM. The zero
+// value, like a nil *ConvertOptions, keeps the source's maxval and averages
+// the channels of colors.
+//
+// Results have their origin at (0, 0) and keep the source's flavor: a plain
+// source (P1, P2, P3) gives a plain result, a raw one a raw result. PBM black
+// maps to 0 and white to the maxval; a PGM or PPM value is black in a PBM
+// when it is below half the maxval. Colors are turned into gray values as
+// selected by Gray, both for PGM and PBM results.
+type ConvertOptions struct {
+	// MaxValue is the maxval of a PGM or PPM result. Zero keeps the source's
+	// maxval, or uses 255 when the source is a PBM.
+	MaxValue uint16
+	Gray     GrayMode
+	// Weights multiply the red, green and blue samples, as fractions of
+	// the maxval, when Gray is GrayWeights. They normally add up to 1;
+	// results outside the range are clipped.
+	Weights [3]float64
+}
+
+// GrayMode selects how a color becomes a gray value.
+type GrayMode int
+
+const (
+	// GrayAverage takes the unweighted mean of the channels.
+	GrayAverage GrayMode = iota
+	// GrayRec601 and GrayRec709 take the luma of the stored, gamma-encoded
+	// samples with the Rec. 601 or Rec. 709 weights.
+	GrayRec601
+	GrayRec709
+	// GrayLinear decodes the samples from sRGB, takes the Rec. 709
+	// luminance in linear light and encodes it back to sRGB.
+	GrayLinear
+	// GrayLightness takes the CIELAB lightness L* of the luminance, scaled
+	// so that L* = 100 is the maxval.
+	GrayLightness
+	// GrayRed, GrayGreen and GrayBlue keep a single channel.
+	GrayRed
+	GrayGreen
+	GrayBlue
+	// GrayWeights uses ConvertOptions.Weights.
+	GrayWeights
+)
+
+// ToPBM converts a PBM, PGM or PPM image to a PBM.
+func (o *ConvertOptions) ToPBM(img PNM) *PBM {
+	width, height := img.Size()
+	bounds := img.Bounds()
+	pbm := NewPBM(width, height)
+	pbm.magicNumber = sameFlavor(img.MagicNumber(), "P1", "P4")
+
+	switch img := img.(type) {
+	case *PBM:
+		pbm.Image = *img.Image.Crop(bounds)
+	case *PGM:
+		img.Each(func(x, y int, value uint16) {
+			pbm.Set(x-bounds.Min.X, y-bounds.Min.Y, isDark(uint32(value), uint32(img.max)))
+		})
+	case *PPM:
+		img.Each(func(x, y int, pixel Pixel) {
+			value, outOf := o.gray(pixel, img.max)
+			pbm.Set(x-bounds.Min.X, y-bounds.Min.Y, isDark(value, outOf))
+		})
+	default:
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				gray := color.Gray16Model.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray16)
+				pbm.Set(x, y, isDark(uint32(gray.Y), 65535))
+			}
+		}
+	}
+	return pbm
+}
+
+// ToPGM converts a PBM, PGM or PPM image to a PGM.
+func (o *ConvertOptions) ToPGM(img PNM) *PGM {
+	width, height := img.Size()
+	bounds := img.Bounds()
+	maxValue := o.maxValue(img)
+	pgm := NewPGM(width, height, maxValue)
+	pgm.magicNumber = sameFlavor(img.MagicNumber(), "P2", "P5")
+
+	switch img := img.(type) {
+	case *PBM:
+		img.Each(func(x, y int, black bool) {
+			if !black {
+				pgm.Set(x-bounds.Min.X, y-bounds.Min.Y, maxValue)
+			}
+		})
+	case *PGM:
+		img.Each(func(x, y int, value uint16) {
+			pgm.Set(x-bounds.Min.X, y-bounds.Min.Y, scaleSample(value, img.max, maxValue))
+		})
+	case *PPM:
+		img.Each(func(x, y int, pixel Pixel) {
+			value, outOf := o.gray(pixel, img.max)
+			pgm.Set(x-bounds.Min.X, y-bounds.Min.Y, scaleSum(value, outOf, maxValue))
+		})
+	default:
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				gray := color.Gray16Model.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray16)
+				pgm.Set(x, y, scaleSample(gray.Y, 65535, maxValue))
+			}
+		}
+	}
+	return pgm
+}
+
+// ToPPM converts a PBM, PGM or PPM image to a PPM. Gray values become
+// equal channels.
+func (o *ConvertOptions) ToPPM(img PNM) *PPM {
+	width, height := img.Size()
+	bounds := img.Bounds()
+	maxValue := o.maxValue(img)
+	ppm := NewPPM(width, height, maxValue)
+	ppm.magicNumber = sameFlavor(img.MagicNumber(), "P3", "P6")
+
+	switch img := img.(type) {
+	case *PBM:
+		white := Pixel{R: maxValue, G: maxValue, B: maxValue}
+		img.Each(func(x, y int, black bool) {
+			if !black {
+				ppm.Set(x-bounds.Min.X, y-bounds.Min.Y, white)
+			}
+		})
+	case *PGM:
+		img.Each(func(x, y int, value uint16) {
+			value = scaleSample(value, img.max, maxValue)
+			ppm.Set(x-bounds.Min.X, y-bounds.Min.Y, Pixel{R: value, G: value, B: value})
+		})
+	case *PPM:
+		img.Each(func(x, y int, pixel Pixel) {
+			ppm.Set(x-bounds.Min.X, y-bounds.Min.Y, Pixel{
+				R: scaleSample(pixel.R, img.max, maxValue),
+				G: scaleSample(pixel.G, img.max, maxValue),
+				B: scaleSample(pixel.B, img.max, maxValue),
+			})
+		})
+	default:
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+				ppm.Set(x, y, Pixel{
+					R: scaleSample(uint16(r), 65535, maxValue),
+					G: scaleSample(uint16(g), 65535, maxValue),
+					B: scaleSample(uint16(b), 65535, maxValue),
+				})
+			}
+		}
+	}
+	return ppm
+}
+
+func (o *ConvertOptions) maxValue(img PNM) uint16 {
+	if o != nil && o.MaxValue != 0 {
+		return o.MaxValue
+	}
+	switch img := img.(type) {
+	case *PGM:
+		return img.max
+	case *PPM:
+		return img.max
+	}
+	return 255
+}
+
+// gray returns the gray value of pixel as value out of outOf. The average
+// and single channels are exact; the other modes are computed in floating
+// point and returned out of 65535.
+func (o *ConvertOptions) gray(pixel Pixel, maxValue uint16) (value, outOf uint32) {
+	mode := GrayAverage
+	if o != nil {
+		mode = o.Gray
+	}
+	switch mode {
+	case GrayRed:
+		return uint32(pixel.R), uint32(maxValue)
+	case GrayGreen:
+		return uint32(pixel.G), uint32(maxValue)
+	case GrayBlue:
+		return uint32(pixel.B), uint32(maxValue)
+	case GrayRec601, GrayRec709, GrayLinear, GrayLightness, GrayWeights:
+	default:
+		return uint32(pixel.R) + uint32(pixel.G) + uint32(pixel.B), 3 * uint32(maxValue)
+	}
+
+	max := float64(maxValue)
+	r, g, b := float64(pixel.R)/max, float64(pixel.G)/max, float64(pixel.B)/max
+	var level float64
+	switch mode {
+	case GrayRec601:
+		level = 0.299*r + 0.587*g + 0.114*b
+	case GrayRec709:
+		level = 0.2126*r + 0.7152*g + 0.0722*b
+	case GrayLinear:
+		level = srgbEncode(luminance(r, g, b))
+	case GrayLightness:
+		level = lightness(luminance(r, g, b)) / 100
+	case GrayWeights:
+		level = o.Weights[0]*r + o.Weights[1]*g + o.Weights[2]*b
+	}
+	level = math.Max(0, math.Min(1, level))
+	return uint32(math.Round(level * 65535)), 65535
+}
+
+// luminance returns the Rec. 709 relative luminance of sRGB-encoded
+// components in [0, 1].
+func luminance(r, g, b float64) float64 {
+	return 0.2126*srgbDecode(r) + 0.7152*srgbDecode(g) + 0.0722*srgbDecode(b)
+}
+
+func srgbDecode(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func srgbEncode(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// lightness returns the CIELAB L* of relative luminance y, from 0 to 100.
+func lightness(y float64) float64 {
+	const epsilon = 216.0 / 24389
+	if y > epsilon {
+		return 116*math.Cbrt(y) - 16
+	}
+	return y * 24389 / 27
+}
+
+// sameFlavor returns plain when magicNumber is a plain format and raw
+// otherwise.
+func sameFlavor(magicNumber, plain, raw string) string {
+	if magicNumber == "P1" || magicNumber == "P2" || magicNumber == "P3" {
+		return plain
+	}
+	return raw
+}
+
+// scaleSum maps sum, out of from, to the nearest value out of to, clamping
+// sums above from.
+func scaleSum(sum, from uint32, to uint16) uint16 {
+	if sum >= from {
+		return to
+	}
+	return uint16((2*uint64(sum)*uint64(to) + uint64(from)) / (2 * uint64(from)))
+}
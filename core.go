@@ -0,0 +1,222 @@
+package Netpbm
+
+import "image"
+
+// Image is the part of PBM, PGM and PPM that does not depend on how pixels
+// are stored: geometry, pixel iteration and drawing are written once here
+// over a store of T values. PBM embeds an Image[bool], PGM an Image[uint16]
+// and PPM an Image[Pixel]. NewImage makes one backed by a plain []T.
+//
+// Coordinates are absolute, as in the image package: a SubImage keeps the
+// coordinates its pixels had in the parent.
+type Image[T any] struct {
+	store store[T]
+	rect  image.Rectangle
+}
+
+// store holds the pixels of an Image. Its coordinates are relative to the
+// image's top-left pixel.
+type store[T any] interface {
+	at(x, y int) T
+	set(x, y int, value T)
+	// sub returns a store sharing memory whose origin is at (x, y).
+	sub(x, y int) store[T]
+	// make returns a new store of the same kind for a width x height image.
+	make(width, height int) store[T]
+	// swapRows exchanges the first width pixels of rows y1 and y2.
+	swapRows(y1, y2, width int)
+}
+
+func NewImage[T any](width, height int) *Image[T] {
+	return &Image[T]{
+		store: newSliceStore[T](width, height),
+		rect:  image.Rect(0, 0, width, height),
+	}
+}
+
+func (img *Image[T]) Size() (int, int) {
+	return img.rect.Dx(), img.rect.Dy()
+}
+
+func (img *Image[T]) Bounds() image.Rectangle {
+	return img.rect
+}
+
+// Get returns the pixel at (x, y), or the zero T outside the bounds.
+func (img *Image[T]) Get(x, y int) T {
+	if !(image.Point{x, y}.In(img.rect)) {
+		var zero T
+		return zero
+	}
+	return img.store.at(x-img.rect.Min.X, y-img.rect.Min.Y)
+}
+
+// Set changes the pixel at (x, y); points outside the bounds are ignored.
+func (img *Image[T]) Set(x, y int, value T) {
+	if !(image.Point{x, y}.In(img.rect)) {
+		return
+	}
+	img.store.set(x-img.rect.Min.X, y-img.rect.Min.Y, value)
+}
+
+// Each calls f for every pixel, row by row.
+func (img *Image[T]) Each(f func(x, y int, value T)) {
+	width, height := img.Size()
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			f(img.rect.Min.X+x, img.rect.Min.Y+y, img.store.at(x, y))
+		}
+	}
+}
+
+// Map replaces every pixel with f of its value.
+func (img *Image[T]) Map(f func(T) T) {
+	width, height := img.Size()
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.store.set(x, y, f(img.store.at(x, y)))
+		}
+	}
+}
+
+// SubImage returns a view of the part of img inside r that shares img's
+// memory. Operations that change the size give the view memory of its own.
+func (img *Image[T]) SubImage(r image.Rectangle) *Image[T] {
+	r = r.Intersect(img.rect)
+	if r.Empty() {
+		return &Image[T]{store: img.store.make(0, 0), rect: r}
+	}
+	return &Image[T]{
+		store: img.store.sub(r.Min.X-img.rect.Min.X, r.Min.Y-img.rect.Min.Y),
+		rect:  r,
+	}
+}
+
+// Crop returns a copy of the part of img inside r, with its origin at (0, 0).
+func (img *Image[T]) Crop(r image.Rectangle) *Image[T] {
+	view := img.SubImage(r)
+	width, height := view.Size()
+	crop := &Image[T]{store: img.store.make(width, height), rect: image.Rect(0, 0, width, height)}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			crop.store.set(x, y, view.store.at(x, y))
+		}
+	}
+	return crop
+}
+
+// Clone returns a copy of img with the same bounds.
+func (img *Image[T]) Clone() *Image[T] {
+	clone := img.Crop(img.rect)
+	clone.rect = img.rect
+	return clone
+}
+
+func (img *Image[T]) Flip() {
+	width, height := img.Size()
+	for y := 0; y < height; y++ {
+		cursor := width - 1
+		for x := 0; x < cursor; x++ {
+			left, right := img.store.at(x, y), img.store.at(cursor, y)
+			img.store.set(x, y, right)
+			img.store.set(cursor, y, left)
+			cursor--
+		}
+	}
+}
+
+func (img *Image[T]) Flop() {
+	width, height := img.Size()
+	cursor := height - 1
+	for y := 0; y < cursor; y++ {
+		img.store.swapRows(y, cursor, width)
+		cursor--
+	}
+}
+
+func (img *Image[T]) Rotate90CW() {
+	width, height := img.Size()
+	rotated := img.store.make(height, width)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			rotated.set(height-1-y, x, img.store.at(x, y))
+		}
+	}
+	img.store = rotated
+	img.resize(height, width)
+}
+
+// KNearestNeighbors scales img to newWidth x newHeight by nearest-neighbor
+// sampling.
+func (img *Image[T]) KNearestNeighbors(newWidth, newHeight int) {
+	scaleX := float64(img.rect.Dx()) / float64(newWidth)
+	scaleY := float64(img.rect.Dy()) / float64(newHeight)
+
+	resized := img.store.make(newWidth, newHeight)
+
+	for y := 0; y < newHeight; y++ {
+		for x := 0; x < newWidth; x++ {
+			originalX := int(float64(x) * scaleX)
+			originalY := int(float64(y) * scaleY)
+
+			resized.set(x, y, img.getNearestNeighbor(originalX, originalY))
+		}
+	}
+
+	img.store = resized
+	img.resize(newWidth, newHeight)
+}
+
+func (img *Image[T]) getNearestNeighbor(x, y int) T {
+	x = clamp(x, 0, img.rect.Dx()-1)
+	y = clamp(y, 0, img.rect.Dy()-1)
+
+	return img.store.at(x, y)
+}
+
+func clamp(value, min, max int) int {
+	if value < min {
+		return min
+	} else if value > max {
+		return max
+	}
+	return value
+}
+
+// resize changes the size of img, keeping its top-left corner.
+func (img *Image[T]) resize(width, height int) {
+	img.rect.Max = img.rect.Min.Add(image.Pt(width, height))
+}
+
+// sliceStore keeps one T per pixel, rows stride elements apart.
+type sliceStore[T any] struct {
+	pix    []T
+	stride int
+}
+
+func newSliceStore[T any](width, height int) sliceStore[T] {
+	return sliceStore[T]{pix: make([]T, width*height), stride: width}
+}
+
+func (s sliceStore[T]) at(x, y int) T {
+	return s.pix[y*s.stride+x]
+}
+
+func (s sliceStore[T]) set(x, y int, value T) {
+	s.pix[y*s.stride+x] = value
+}
+
+func (s sliceStore[T]) sub(x, y int) store[T] {
+	return sliceStore[T]{pix: s.pix[y*s.stride+x:], stride: s.stride}
+}
+
+func (s sliceStore[T]) make(width, height int) store[T] {
+	return newSliceStore[T](width, height)
+}
+
+func (s sliceStore[T]) swapRows(y1, y2, width int) {
+	row1, row2 := s.pix[y1*s.stride:y1*s.stride+width], s.pix[y2*s.stride:y2*s.stride+width]
+	for i := range row1 {
+		row1[i], row2[i] = row2[i], row1[i]
+	}
+}
@@ -0,0 +1,106 @@
+package Netpbm
+
+import (
+	"errors"
+	"image"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDecodeHugeHeaders(t *testing.T) {
+	tests := []struct {
+		input string
+		err   error
+	}{
+		{"P1 2147483647 2147483647\n", ErrTruncated},
+		{"P2 2147483647 2147483647 65535\n", ErrTruncated},
+		{"P3 2147483647 2147483647 255\n", ErrTooLarge},
+		{"P4 2147483647 2147483647\n", ErrTruncated},
+		{"P5 2147483647 2147483647 65535\n", ErrTruncated},
+		{"P6 2147483647 2147483647 65535\n", ErrTooLarge},
+		{"P6 100000 100000 255\n\x00\x00\x00", ErrTruncated},
+		{"P7\nWIDTH 2147483647\nHEIGHT 2147483647\nDEPTH 1\nMAXVAL 255\nENDHDR\n", ErrTruncated},
+		{"PF\n2147483647 2147483647\n-1\n", ErrTooLarge},
+	}
+	for _, test := range tests {
+		var err error
+		switch test.input[1] {
+		case '7':
+			_, err = DecodePAM(strings.NewReader(test.input))
+		case 'F':
+			_, err = DecodePFM(strings.NewReader(test.input))
+		default:
+			_, err = Decode(strings.NewReader(test.input))
+			if _, _, imageErr := image.Decode(strings.NewReader(test.input)); !errors.Is(imageErr, test.err) {
+				t.Errorf("image.Decode(%q): got %v, want %v", test.input, imageErr, test.err)
+			}
+		}
+		if !errors.Is(err, test.err) {
+			t.Errorf("decoding %q: got %v, want %v", test.input, err, test.err)
+		}
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	tests := []struct {
+		input  string
+		line   int
+		offset int64
+	}{
+		{"P2 3 2 65536\n", 1, 7},
+		{"P2\n3 x\n", 2, 5},
+		{"P2\n# comment\n3 2\n255\n1 2 300\n", 5, 25},
+		{"P7\nWIDTH 1\nHEIGHT x\n", 3, 11},
+	}
+	for _, test := range tests {
+		var err error
+		if strings.HasPrefix(test.input, "P7") {
+			_, err = DecodePAM(strings.NewReader(test.input))
+		} else {
+			_, err = Decode(strings.NewReader(test.input))
+		}
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("decoding %q: got %v, want a ParseError", test.input, err)
+		}
+		if parseErr.Line != test.line || parseErr.Offset != test.offset {
+			t.Errorf("decoding %q: got line %d, byte %d, want line %d, byte %d",
+				test.input, parseErr.Line, parseErr.Offset, test.line, test.offset)
+		}
+	}
+}
+
+func TestDecodeShortStreamAllocation(t *testing.T) {
+	input := "P6 100000 100000 255\n" + strings.Repeat("\x00", 1000)
+	decoders := map[string]func() error{
+		"DecodePPM": func() error {
+			_, err := DecodePPM(strings.NewReader(input))
+			return err
+		},
+		"Decode": func() error {
+			_, err := Decode(strings.NewReader(input))
+			return err
+		},
+		"Decoder": func() error {
+			_, err := NewDecoder(strings.NewReader(input), nil).Next()
+			return err
+		},
+		"image.Decode": func() error {
+			_, _, err := image.Decode(strings.NewReader(input))
+			return err
+		},
+	}
+	for name, decode := range decoders {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		err := decode()
+		runtime.ReadMemStats(&after)
+		if !errors.Is(err, ErrTruncated) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrTruncated)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 8<<20 {
+			t.Errorf("%s: allocated %d bytes for a 1000 byte raster", name, allocated)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package Netpbm
+
+import "math"
+
+// DitherMethod selects how DitherOptions hides quantization error.
+type DitherMethod int
+
+const (
+	// Error diffusion methods pass the error of each pixel on to the
+	// pixels after it.
+	DitherFloydSteinberg DitherMethod = iota
+	DitherAtkinson
+	DitherJarvisJudiceNinke
+	DitherStucki
+	DitherSierra
+	// DitherBayer compares pixels with a tiled Bayer threshold matrix.
+	DitherBayer
+)
+
+// DitherOptions controls dithering. A nil *DitherOptions uses
+// Floyd–Steinberg error diffusion.
+type DitherOptions struct {
+	Method DitherMethod
+	// Serpentine scans every other row right to left, with the diffusion
+	// kernel mirrored, which avoids the diagonal artifacts of raster order.
+	Serpentine bool
+	// MatrixSize is the side of the Bayer matrix. Zero means 4; sizes that
+	// are not a power of two are rounded up to one.
+	MatrixSize int
+	// Spread scales the Bayer offsets of ToPPM, as a fraction of the
+	// maxval. Zero means the step between the levels of a palette spread
+	// evenly over the color cube.
+	Spread float64
+	// Palette lists the colors of ToPPM, with samples out of the maxval of
+	// the image (255 for a PBM). An empty palette means black, white and
+	// the six primary and secondary colors.
+	Palette []Pixel
+	// Gray selects how colors become gray for ToPBM; nil averages the
+	// channels.
+	Gray *ConvertOptions
+}
+
+// errorKernel lists the share of the error each neighbor receives: dx and
+// dy are relative to the current pixel, in the direction of the scan.
+type errorKernel struct {
+	taps    []errorTap
+	divisor float64
+}
+
+type errorTap struct {
+	dx, dy int
+	weight float64
+}
+
+var errorKernels = map[DitherMethod]errorKernel{
+	DitherFloydSteinberg: {[]errorTap{
+		{1, 0, 7},
+		{-1, 1, 3}, {0, 1, 5}, {1, 1, 1},
+	}, 16},
+	DitherAtkinson: {[]errorTap{
+		{1, 0, 1}, {2, 0, 1},
+		{-1, 1, 1}, {0, 1, 1}, {1, 1, 1},
+		{0, 2, 1},
+	}, 8},
+	DitherJarvisJudiceNinke: {[]errorTap{
+		{1, 0, 7}, {2, 0, 5},
+		{-2, 1, 3}, {-1, 1, 5}, {0, 1, 7}, {1, 1, 5}, {2, 1, 3},
+		{-2, 2, 1}, {-1, 2, 3}, {0, 2, 5}, {1, 2, 3}, {2, 2, 1},
+	}, 48},
+	DitherStucki: {[]errorTap{
+		{1, 0, 8}, {2, 0, 4},
+		{-2, 1, 2}, {-1, 1, 4}, {0, 1, 8}, {1, 1, 4}, {2, 1, 2},
+		{-2, 2, 1}, {-1, 2, 2}, {0, 2, 4}, {1, 2, 2}, {2, 2, 1},
+	}, 42},
+	DitherSierra: {[]errorTap{
+		{1, 0, 5}, {2, 0, 3},
+		{-2, 1, 2}, {-1, 1, 4}, {0, 1, 5}, {1, 1, 4}, {2, 1, 2},
+		{-1, 2, 2}, {0, 2, 3}, {1, 2, 2},
+	}, 32},
+}
+
+// ToPBM dithers a PBM, PGM or PPM image to a PBM. The result has its origin
+// at (0, 0) and keeps the source's flavor.
+func (o *DitherOptions) ToPBM(img PNM) *PBM {
+	var opts DitherOptions
+	if o != nil {
+		opts = *o
+	}
+	pgm := opts.Gray.ToPGM(img)
+	width, height := pgm.Size()
+	pbm := NewPBM(width, height)
+	pbm.magicNumber = sameFlavor(pgm.magicNumber, "P1", "P4")
+
+	levels := make([]float64, 0, width*height)
+	pgm.Each(func(x, y int, value uint16) {
+		levels = append(levels, float64(value)/float64(pgm.max))
+	})
+	// White comes first so that a level of exactly one half is white, as
+	// in ToPBM.
+	palette := [][]float64{{1}, {0}}
+	chosen := opts.dither(levels, width, height, 1, palette, 1)
+	for i, index := range chosen {
+		pbm.Set(i%width, i/width, index == 1)
+	}
+	return pbm
+}
+
+// ToPPM dithers a PBM, PGM or PPM image to a PPM using only the colors of
+// the palette, matched by Euclidean distance. The result has its origin at
+// (0, 0), the source's maxval and the source's flavor.
+func (o *DitherOptions) ToPPM(img PNM) *PPM {
+	var opts DitherOptions
+	if o != nil {
+		opts = *o
+	}
+	ppm := (*ConvertOptions)(nil).ToPPM(img)
+	width, height := ppm.Size()
+	max := float64(ppm.max)
+
+	colors := opts.Palette
+	if len(colors) == 0 {
+		colors = make([]Pixel, 0, 8)
+		for i := 0; i < 8; i++ {
+			colors = append(colors, Pixel{
+				R: uint16(i&1) * ppm.max,
+				G: uint16(i>>1&1) * ppm.max,
+				B: uint16(i>>2&1) * ppm.max,
+			})
+		}
+	}
+	palette := make([][]float64, len(colors))
+	for i, color := range colors {
+		palette[i] = []float64{float64(color.R) / max, float64(color.G) / max, float64(color.B) / max}
+	}
+
+	levels := make([]float64, 0, width*height*3)
+	ppm.Each(func(x, y int, pixel Pixel) {
+		levels = append(levels, float64(pixel.R)/max, float64(pixel.G)/max, float64(pixel.B)/max)
+	})
+	spread := opts.Spread
+	if spread == 0 {
+		steps := math.Max(1, math.Round(math.Cbrt(float64(len(palette))))-1)
+		spread = 1 / steps
+	}
+	chosen := opts.dither(levels, width, height, 3, palette, spread)
+	for i, index := range chosen {
+		ppm.Set(i%width, i/width, colors[index])
+	}
+	return ppm
+}
+
+// dither picks an entry of palette for each of the width x height pixels
+// of levels, given row by row with channels values each, and returns their
+// indices. levels is used as the error buffer.
+func (o *DitherOptions) dither(levels []float64, width, height, channels int, palette [][]float64, spread float64) []int {
+	chosen := make([]int, width*height)
+	if o.Method == DitherBayer {
+		size := o.matrixSize()
+		matrix := bayerMatrix(size)
+		offset := make([]float64, channels)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				t := (float64(matrix[(y%size)*size+x%size])+0.5)/float64(size*size) - 0.5
+				pixel := levels[(y*width+x)*channels:][:channels]
+				for c := range offset {
+					offset[c] = pixel[c] + spread*t
+				}
+				chosen[y*width+x] = nearest(palette, offset)
+			}
+		}
+		return chosen
+	}
+
+	kernel, ok := errorKernels[o.Method]
+	if !ok {
+		kernel = errorKernels[DitherFloydSteinberg]
+	}
+	errs := make([]float64, channels)
+	for y := 0; y < height; y++ {
+		x, step, end := 0, 1, width
+		if o.Serpentine && y%2 == 1 {
+			x, step, end = width-1, -1, -1
+		}
+		for ; x != end; x += step {
+			pixel := levels[(y*width+x)*channels:][:channels]
+			index := nearest(palette, pixel)
+			chosen[y*width+x] = index
+			for c := range errs {
+				errs[c] = pixel[c] - palette[index][c]
+			}
+			for _, tap := range kernel.taps {
+				nx, ny := x+tap.dx*step, y+tap.dy
+				if nx < 0 || nx >= width || ny >= height {
+					continue
+				}
+				neighbor := levels[(ny*width+nx)*channels:][:channels]
+				for c, e := range errs {
+					neighbor[c] += e * tap.weight / kernel.divisor
+				}
+			}
+		}
+	}
+	return chosen
+}
+
+func (o *DitherOptions) matrixSize() int {
+	size := 4
+	if o.MatrixSize > 0 {
+		size = 2
+		for size < o.MatrixSize {
+			size *= 2
+		}
+	}
+	return size
+}
+
+// bayerMatrix returns the size x size Bayer index matrix, row by row, for
+// a power of two size.
+func bayerMatrix(size int) []int {
+	matrix := []int{0}
+	for n := 1; n < size; n *= 2 {
+		next := make([]int, 4*n*n)
+		for y := 0; y < n; y++ {
+			for x := 0; x < n; x++ {
+				v := 4 * matrix[y*n+x]
+				next[y*2*n+x] = v
+				next[y*2*n+x+n] = v + 2
+				next[(y+n)*2*n+x] = v + 3
+				next[(y+n)*2*n+x+n] = v + 1
+			}
+		}
+		matrix = next
+	}
+	return matrix
+}
+
+// nearest returns the index of the first entry of palette closest to pixel.
+func nearest(palette [][]float64, pixel []float64) int {
+	best, index := math.Inf(1), 0
+	for i, color := range palette {
+		distance := 0.0
+		for c, v := range color {
+			d := pixel[c] - v
+			distance += d * d
+		}
+		if distance < best {
+			best, index = distance, i
+		}
+	}
+	return index
+}
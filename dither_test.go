@@ -0,0 +1,157 @@
+package Netpbm
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+var ditherMethods = []struct {
+	name   string
+	method DitherMethod
+}{
+	{"FloydSteinberg", DitherFloydSteinberg},
+	{"Atkinson", DitherAtkinson},
+	{"JarvisJudiceNinke", DitherJarvisJudiceNinke},
+	{"Stucki", DitherStucki},
+	{"Sierra", DitherSierra},
+	{"Bayer", DitherBayer},
+}
+
+// rampCoverage dithers a 256 x 64 horizontal ramp from black to white and
+// returns the fraction of black pixels in each quarter of its width.
+func rampCoverage(opts *DitherOptions) [4]float64 {
+	pgm := NewPGM(256, 64, 255)
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 256; x++ {
+			pgm.Set(x, y, uint16(x))
+		}
+	}
+	var coverage [4]float64
+	opts.ToPBM(pgm).Each(func(x, y int, black bool) {
+		if black {
+			coverage[x/64] += 1.0 / (64 * 64)
+		}
+	})
+	return coverage
+}
+
+func TestDitherRampCoverage(t *testing.T) {
+	// The quarters average 1/8, 3/8, 5/8 and 7/8 of white.
+	want := [4]float64{0.875, 0.625, 0.375, 0.125}
+	for _, method := range ditherMethods {
+		// Atkinson diffuses only 6/8 of the error, which pushes the dark and
+		// light ends towards black and white.
+		tolerance := 0.01
+		if method.method == DitherAtkinson {
+			tolerance = 0.08
+		}
+		for _, serpentine := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s-%v", method.name, serpentine), func(t *testing.T) {
+				got := rampCoverage(&DitherOptions{Method: method.method, Serpentine: serpentine})
+				for i := range got {
+					if math.Abs(got[i]-want[i]) > tolerance {
+						t.Errorf("quarter %d is %.3f black, want %.3f", i, got[i], want[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestDitherSerpentine checks that a serpentine scan covers the ramp like a
+// raster scan does. A kernel that isn't mirrored on right-to-left rows
+// sends error back to pixels already chosen, which shifts the coverage of
+// every quarter by several percent.
+func TestDitherSerpentine(t *testing.T) {
+	for _, method := range ditherMethods {
+		t.Run(method.name, func(t *testing.T) {
+			raster := rampCoverage(&DitherOptions{Method: method.method})
+			serpentine := rampCoverage(&DitherOptions{Method: method.method, Serpentine: true})
+			for i := range raster {
+				if math.Abs(serpentine[i]-raster[i]) > 0.01 {
+					t.Errorf("quarter %d is %.3f black in serpentine order and %.3f in raster order", i, serpentine[i], raster[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDitherPaletteMeans(t *testing.T) {
+	for _, test := range []struct {
+		color   Pixel
+		palette []Pixel
+	}{
+		{Pixel{200, 100, 50}, nil},
+		{Pixel{30, 240, 128}, nil},
+		{Pixel{64, 64, 64}, []Pixel{{0, 0, 0}, {128, 128, 128}, {255, 255, 255}}},
+		{Pixel{200, 200, 200}, []Pixel{{0, 0, 0}, {128, 128, 128}, {255, 255, 255}}},
+	} {
+		for _, method := range ditherMethods {
+			// Atkinson loses a quarter of the error, so its means drift.
+			if method.method == DitherAtkinson {
+				continue
+			}
+			tolerance := 4.0
+			if method.method == DitherBayer {
+				tolerance = 10
+			}
+			t.Run(fmt.Sprintf("%s-%v-%d", method.name, test.color, len(test.palette)), func(t *testing.T) {
+				ppm := NewPPM(64, 64, 255)
+				ppm.Map(func(Pixel) Pixel { return test.color })
+				var sum [3]float64
+				(&DitherOptions{Method: method.method, Palette: test.palette}).ToPPM(ppm).Each(func(x, y int, pixel Pixel) {
+					sum[0] += float64(pixel.R)
+					sum[1] += float64(pixel.G)
+					sum[2] += float64(pixel.B)
+				})
+				want := [3]uint16{test.color.R, test.color.G, test.color.B}
+				for c := range sum {
+					if mean := sum[c] / (64 * 64); math.Abs(mean-float64(want[c])) > tolerance {
+						t.Errorf("channel %d: mean %.1f, want %d", c, mean, want[c])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestBayerMatrix(t *testing.T) {
+	want := []int{
+		0, 8, 2, 10,
+		12, 4, 14, 6,
+		3, 11, 1, 9,
+		15, 7, 13, 5,
+	}
+	if got := bayerMatrix(4); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got 4x4 matrix %v, want %v", got, want)
+	}
+
+	for _, size := range []int{2, 8, 16} {
+		seen := make([]bool, size*size)
+		for _, v := range bayerMatrix(size) {
+			if v < 0 || v >= len(seen) || seen[v] {
+				t.Fatalf("size %d: index %d out of range or repeated", size, v)
+			}
+			seen[v] = true
+		}
+	}
+
+	// A flat level of j/16 leaves exactly j white pixels in every 4x4 tile.
+	for j := 0; j <= 16; j++ {
+		pgm := NewPGM(8, 8, 16)
+		pgm.Map(func(uint16) uint16 { return uint16(j) })
+		pbm := (&DitherOptions{Method: DitherBayer}).ToPBM(pgm)
+		var white [4]int
+		pbm.Each(func(x, y int, black bool) {
+			if !black {
+				white[y/4*2+x/4]++
+			}
+		})
+		for tile, count := range white {
+			if count != j {
+				t.Errorf("level %d/16: tile %d has %d white pixels", j, tile, count)
+			}
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package Netpbm
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+type Point struct {
+	X, Y int
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func sign(x int) int {
+	if x > 0 {
+		return 1
+	} else if x < 0 {
+		return -1
+	}
+	return 0
+}
+
+func (img *Image[T]) DrawLine(p1, p2 Point, color T) {
+	deltaX := abs(p2.X - p1.X)
+	deltaY := abs(p2.Y - p1.Y)
+	sx, sy := sign(p2.X-p1.X), sign(p2.Y-p1.Y)
+	err := deltaX - deltaY
+
+	for {
+		if (image.Point{p1.X, p1.Y}.In(img.rect)) {
+			img.Set(p1.X, p1.Y, color)
+		}
+
+		if p1.X == p2.X && p1.Y == p2.Y {
+			break
+		}
+
+		e2 := 2 * err
+
+		if e2 > -deltaY {
+			err -= deltaY
+			p1.X += sx
+		}
+
+		if e2 < deltaX {
+			err += deltaX
+			p1.Y += sy
+		}
+	}
+}
+
+func (img *Image[T]) DrawRectangle(p1 Point, width, height int, color T) {
+	p2 := Point{p1.X + width, p1.Y}
+	p3 := Point{p1.X, p1.Y + height}
+	p4 := Point{p1.X + width, p1.Y + height}
+
+	img.DrawLine(p1, p2, color)
+	img.DrawLine(p2, p4, color)
+	img.DrawLine(p4, p3, color)
+	img.DrawLine(p3, p1, color)
+}
+
+func (img *Image[T]) DrawFilledRectangle(p1 Point, width, height int, color T) {
+	p2 := Point{p1.X + width, p1.Y}
+
+	for i := 0; i <= height; i++ {
+		img.DrawLine(p1, p2, color)
+		p1.Y++
+		p2.Y++
+	}
+}
+
+func (img *Image[T]) DrawCircle(center Point, radius int, color T) {
+	for y := img.rect.Min.Y; y < img.rect.Max.Y; y++ {
+		for x := img.rect.Min.X; x < img.rect.Max.X; x++ {
+			dx := float64(x - center.X)
+			dy := float64(y - center.Y)
+			distance := math.Sqrt(dx*dx + dy*dy)
+
+			if math.Abs(distance-float64(radius)*0.85) < 0.5 {
+				img.Set(x, y, color)
+			}
+		}
+	}
+}
+
+func (img *Image[T]) DrawFilledCircle(center Point, radius int, color T) {
+	for radius >= 0 {
+		img.DrawCircle(center, radius, color)
+		radius--
+	}
+}
+
+func (img *Image[T]) DrawTriangle(p1, p2, p3 Point, color T) {
+	img.DrawLine(p1, p2, color)
+	img.DrawLine(p2, p3, color)
+	img.DrawLine(p3, p1, color)
+}
+
+func (img *Image[T]) DrawFilledTriangle(p1, p2, p3 Point, color T) {
+	for p1 != p2 {
+		img.DrawLine(p3, p1, color)
+
+		if p1.X != p2.X {
+			p1.X += sign(p2.X - p1.X)
+		}
+
+		if p1.Y != p2.Y {
+			p1.Y += sign(p2.Y - p1.Y)
+		}
+	}
+
+	img.DrawLine(p3, p1, color)
+}
+
+func (img *Image[T]) DrawPolygon(points []Point, color T) {
+	for i := 0; i < len(points)-1; i++ {
+		img.DrawLine(points[i], points[i+1], color)
+	}
+
+	img.DrawLine(points[len(points)-1], points[0], color)
+}
+
+func (img *Image[T]) DrawFilledPolygon(points []Point, color T) {
+	if len(points) < 3 {
+		return
+	}
+
+	minY, maxY := boundingBoxY(points)
+
+	for y := minY; y <= maxY; y++ {
+		intersections := findIntersections(points, y)
+
+		sort.Sort(intersectionSlice(intersections))
+
+		for i := 0; i < len(intersections); i += 2 {
+			x1 := intersections[i]
+			x2 := intersections[i+1]
+
+			for x := x1; x <= x2; x++ {
+				img.Set(x, y, color)
+			}
+		}
+	}
+}
+
+func boundingBoxY(points []Point) (minY, maxY int) {
+	if len(points) == 0 {
+		return 0, 0
+	}
+
+	minY, maxY = points[0].Y, points[0].Y
+	for _, p := range points {
+		if p.Y < minY {
+			minY = p.Y
+		}
+		if p.Y > maxY {
+			maxY = p.Y
+		}
+	}
+	return minY, maxY
+}
+
+func boundingBox(points []Point) (int, int, int, int) {
+	minX, minY, maxX, maxY := points[0].X, points[0].Y, points[0].X, points[0].Y
+
+	for _, point := range points {
+		if point.X < minX {
+			minX = point.X
+		}
+		if point.X > maxX {
+			maxX = point.X
+		}
+		if point.Y < minY {
+			minY = point.Y
+		}
+		if point.Y > maxY {
+			maxY = point.Y
+		}
+	}
+
+	return minX, minY, maxX, maxY
+}
+
+func findIntersections(points []Point, y int) []int {
+	intersections := make([]int, 0)
+
+	for i := 0; i < len(points); i++ {
+		j := (i + 1) % len(points)
+
+		y1, y2 := points[i].Y, points[j].Y
+
+		if (y1 <= y && y < y2) || (y2 <= y && y < y1) {
+			x := int(float64(y-y1)*(float64(points[j].X-points[i].X)/float64(y2-y1))) + points[i].X
+			intersections = append(intersections, x)
+		}
+	}
+
+	return intersections
+}
+
+type intersectionSlice []int
+
+func (s intersectionSlice) Len() int           { return len(s) }
+func (s intersectionSlice) Less(i, j int) bool { return s[i] < s[j] }
+func (s intersectionSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (img *Image[T]) DrawKochSnowflake(n int, start Point, width int, color T) {
+	var drawKoch func(level int, p1, p2 Point)
+
+	p1 := start
+	p2 := Point{X: start.X + width, Y: start.Y}
+
+	drawKoch = func(level int, p1, p2 Point) {
+		if level == 0 {
+			img.DrawLine(p1, p2, color)
+		} else {
+			dx := p2.X - p1.X
+			dy := p2.Y - p1.Y
+
+			p1_3 := Point{X: p1.X + dx/3, Y: p1.Y + dy/3}
+			p2_3 := Point{X: p1.X + 2*dx/3, Y: p1.Y + 2*dy/3}
+
+			angle := math.Pi / 3.0
+			cosAngle := math.Cos(angle)
+			sinAngle := math.Sin(angle)
+			pTriangle := Point{
+				X: int(float64(p1_3.X-p2_3.X)*cosAngle-float64(p1_3.Y-p2_3.Y)*sinAngle) + p2_3.X,
+				Y: int(float64(p1_3.X-p2_3.X)*sinAngle+float64(p1_3.Y-p2_3.Y)*cosAngle) + p2_3.Y,
+			}
+
+			drawKoch(level-1, p1, p1_3)
+			drawKoch(level-1, p1_3, pTriangle)
+			drawKoch(level-1, pTriangle, p2_3)
+			drawKoch(level-1, p2_3, p2)
+		}
+	}
+
+	drawKoch(n, p1, p2)
+}
+
+func (img *Image[T]) DrawSierpinskiTriangle(n int, start Point, width int, color T) {
+	var drawSierpinski func(level int, p1, p2, p3 Point)
+
+	height := int(float64(width) * math.Sqrt(3) / 2)
+	p1 := start
+	p2 := Point{X: start.X + width, Y: start.Y}
+	p3 := Point{X: start.X + width/2, Y: start.Y + height}
+
+	drawSierpinski = func(level int, p1, p2, p3 Point) {
+		if level == 0 {
+			img.DrawFilledTriangle(p1, p2, p3, color)
+		} else {
+			pMiddle1 := Point{X: (p1.X + p2.X) / 2, Y: (p1.Y + p2.Y) / 2}
+			pMiddle2 := Point{X: (p2.X + p3.X) / 2, Y: (p2.Y + p3.Y) / 2}
+			pMiddle3 := Point{X: (p3.X + p1.X) / 2, Y: (p3.Y + p1.Y) / 2}
+
+			drawSierpinski(level-1, p1, pMiddle1, pMiddle3)
+			drawSierpinski(level-1, pMiddle1, p2, pMiddle2)
+			drawSierpinski(level-1, pMiddle3, pMiddle2, p3)
+		}
+	}
+
+	drawSierpinski(n, p1, p2, p3)
+}
@@ -0,0 +1,39 @@
+package Netpbm
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrBadMagic         = errors.New("bad magic number")
+	ErrBadDimensions    = errors.New("bad dimensions")
+	ErrBadMaxValue      = errors.New("bad max value")
+	ErrSyntax           = errors.New("syntax error")
+	ErrTruncated        = errors.New("truncated data")
+	ErrSampleOutOfRange = errors.New("sample out of range")
+	ErrTooLarge         = errors.New("image exceeds decode limits")
+)
+
+// ParseError reports malformed input. Err is one of the sentinel errors
+// above, or the underlying I/O error, and can be tested with errors.Is.
+// Line is zero inside raw rasters, where lines have no meaning.
+type ParseError struct {
+	Format string
+	Line   int
+	Offset int64
+	Reason string
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	position := fmt.Sprintf("byte %d", e.Offset)
+	if e.Line > 0 {
+		position = fmt.Sprintf("line %d, %s", e.Line, position)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Format, position, e.Reason)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
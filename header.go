@@ -0,0 +1,416 @@
+package Netpbm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// Header describes a PBM, PGM or PPM image without its pixel data.
+// Offset is the number of bytes from the start of the header to the
+// first byte of the raster.
+type Header struct {
+	MagicNumber string
+	Width       int
+	Height      int
+	MaxValue    uint16
+	Offset      int64
+	Comments    []string
+}
+
+func ReadHeader(filename string) (Header, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return Header{}, err
+	}
+	defer file.Close()
+
+	return DecodeHeader(file)
+}
+
+// DecodeHeader parses the header of a PBM, PGM or PPM image, leaving r
+// positioned at the start of the raster when r is a *bufio.Reader.
+func DecodeHeader(r io.Reader) (Header, error) {
+	return readHeader(newTokenReader(r, ""))
+}
+
+func readHeader(tr *tokenReader) (Header, error) {
+	var header Header
+	var err error
+
+	if header.MagicNumber, err = tr.magic(); err != nil {
+		return Header{}, err
+	}
+	format := formatName(header.MagicNumber)
+	if format == "" {
+		return Header{}, tr.errorf(ErrBadMagic, "unknown magic number %q", header.MagicNumber)
+	}
+	if tr.format == "" {
+		tr.format = format
+	}
+
+	if header.Width, header.Height, err = tr.dimensions(); err != nil {
+		return Header{}, err
+	}
+
+	header.MaxValue = 1
+	if format != "PBM" {
+		if header.MaxValue, err = tr.maxValue(); err != nil {
+			return Header{}, err
+		}
+	}
+
+	width, size := header.Width, bytesPerSample(header.MaxValue)
+	switch format {
+	case "PBM":
+		err = tr.checkRasterSize(width, header.Height, (width+63)/64, 8)
+	case "PGM":
+		err = tr.checkRasterSize(width, header.Height, width, size)
+	case "PPM":
+		err = tr.checkRasterSize(width, header.Height, width, 3, size)
+	}
+	if err != nil {
+		return Header{}, err
+	}
+
+	header.Offset = tr.offset
+	header.Comments, tr.comments = tr.comments, nil
+	return header, nil
+}
+
+func formatName(magicNumber string) string {
+	switch magicNumber {
+	case "P1", "P4":
+		return "PBM"
+	case "P2", "P5":
+		return "PGM"
+	case "P3", "P6":
+		return "PPM"
+	}
+	return ""
+}
+
+// tokenReader splits a header into whitespace-delimited tokens and keeps
+// track of the position reached, for error reporting. Errors refer to the
+// start of the last token read, recorded by mark.
+type tokenReader struct {
+	br          *bufio.Reader
+	format      string
+	offset      int64
+	line        int
+	limit       int64
+	startOffset int64
+	startLine   int
+
+	comments []string
+}
+
+var errByteLimit = errors.New("byte limit reached")
+
+func newTokenReader(r io.Reader, format string) *tokenReader {
+	return &tokenReader{br: newReader(r), format: format, line: 1, startLine: 1}
+}
+
+// mark records the current position as the start of a token.
+func (tr *tokenReader) mark() {
+	tr.startOffset, tr.startLine = tr.offset, tr.line
+}
+
+func (tr *tokenReader) errorf(err error, format string, args ...interface{}) error {
+	name := tr.format
+	if name == "" {
+		name = "PNM"
+	}
+	return &ParseError{
+		Format: name,
+		Line:   tr.startLine,
+		Offset: tr.startOffset,
+		Reason: fmt.Sprintf(format, args...),
+		Err:    err,
+	}
+}
+
+// readError turns an error returned while reading what into a ParseError
+// at the current position.
+func (tr *tokenReader) readError(err error, what string) error {
+	tr.mark()
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return tr.errorf(ErrTruncated, "unexpected end of data in %s", what)
+	}
+	if err == errByteLimit {
+		return tr.errorf(ErrTooLarge, "%s exceeds limit of %d bytes", what, tr.limit)
+	}
+	return tr.errorf(err, "couldn't read %s: %v", what, err)
+}
+
+// startRaw marks the start of a raw raster, in which lines are not counted.
+func (tr *tokenReader) startRaw() {
+	tr.line = 0
+}
+
+func (tr *tokenReader) Read(p []byte) (int, error) {
+	if tr.limit > 0 {
+		if tr.offset >= tr.limit {
+			return 0, errByteLimit
+		}
+		if remaining := tr.limit - tr.offset; int64(len(p)) > remaining {
+			p = p[:remaining]
+		}
+	}
+	n, err := tr.br.Read(p)
+	tr.offset += int64(n)
+	return n, err
+}
+
+func (tr *tokenReader) readByte() (byte, error) {
+	if tr.limit > 0 && tr.offset >= tr.limit {
+		return 0, errByteLimit
+	}
+	c, err := tr.br.ReadByte()
+	if err == nil {
+		tr.offset++
+		if c == '\n' && tr.line > 0 {
+			tr.line++
+		}
+	}
+	return c, err
+}
+
+func (tr *tokenReader) readLine() (string, error) {
+	var line []byte
+	for {
+		c, err := tr.readByte()
+		if err != nil {
+			return string(line), err
+		}
+		line = append(line, c)
+		if c == '\n' {
+			return string(line), nil
+		}
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\v' || c == '\f' || c == '\r'
+}
+
+// magic reads the two-byte magic number, which must be the very first bytes
+// of an image and be followed by whitespace or a comment.
+func (tr *tokenReader) magic() (string, error) {
+	tr.mark()
+	var magic [2]byte
+	if _, err := io.ReadFull(tr, magic[:]); err != nil {
+		return "", tr.readError(err, "magic number")
+	}
+	next, err := tr.br.Peek(1)
+	if err != nil {
+		return "", tr.readError(err, "header")
+	}
+	if !isSpace(next[0]) && next[0] != '#' {
+		return "", tr.errorf(ErrBadMagic, "invalid magic number %q", string(magic[:])+string(next))
+	}
+	return string(magic[:]), nil
+}
+
+// token returns the next whitespace-delimited token. A comment runs from
+// '#' to the next CR or LF and may appear anywhere between tokens, or
+// directly after one, in which case it ends the token. The single
+// whitespace character (or comment) ending the token is consumed, so after
+// the last header token the reader is positioned at the raster.
+func (tr *tokenReader) token(what string) (string, error) {
+	var token []byte
+	for {
+		c, err := tr.readByte()
+		if err != nil {
+			if err == io.EOF && len(token) > 0 {
+				return string(token), nil
+			}
+			return "", tr.readError(err, what)
+		}
+
+		if c == '#' {
+			tr.comments = append(tr.comments, tr.skipComment())
+			if len(token) > 0 {
+				return string(token), nil
+			}
+			continue
+		}
+
+		if isSpace(c) {
+			if len(token) > 0 {
+				return string(token), nil
+			}
+			continue
+		}
+
+		if len(token) == 0 {
+			tr.startOffset, tr.startLine = tr.offset-1, tr.line
+		}
+		token = append(token, c)
+	}
+}
+
+// skipComment consumes the rest of a comment up to and including the CR or
+// LF that ends it, and returns its text without the leading space.
+func (tr *tokenReader) skipComment() string {
+	var comment []byte
+	for {
+		c, err := tr.readByte()
+		if err != nil || c == '\n' || c == '\r' {
+			return strings.TrimPrefix(string(comment), " ")
+		}
+		comment = append(comment, c)
+	}
+}
+
+// bit reads a single plain PBM pixel. Pixels need not be separated by
+// whitespace, so "0110" holds four of them.
+func (tr *tokenReader) bit() (bool, error) {
+	for {
+		tr.mark()
+		c, err := tr.readByte()
+		if err != nil {
+			return false, tr.readError(err, "raster")
+		}
+
+		switch {
+		case c == '0':
+			return false, nil
+		case c == '1':
+			return true, nil
+		case c >= '2' && c <= '9':
+			return false, tr.errorf(ErrSampleOutOfRange, "pixel value %q is not 0 or 1", c)
+		case c == '#':
+			tr.skipComment()
+		case !isSpace(c):
+			return false, tr.errorf(ErrSyntax, "invalid pixel %q", c)
+		}
+	}
+}
+
+// parseUint parses a string made only of ASCII decimal digits.
+func parseUint(token string) (int, bool) {
+	if token == "" {
+		return 0, false
+	}
+	value := 0
+	for i := 0; i < len(token); i++ {
+		c := token[i]
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		value = value*10 + int(c-'0')
+		if value > math.MaxInt32 {
+			return 0, false
+		}
+	}
+	return value, true
+}
+
+func (tr *tokenReader) int(what string) (int, error) {
+	token, err := tr.token(what)
+	if err != nil {
+		return 0, err
+	}
+	value, ok := parseUint(token)
+	if !ok {
+		return 0, tr.errorf(ErrSyntax, "invalid %s %q", what, token)
+	}
+	return value, nil
+}
+
+// sample reads a plain sample and checks it against maxValue.
+func (tr *tokenReader) sample(maxValue uint16) (uint16, error) {
+	value, err := tr.int("sample")
+	if err != nil {
+		return 0, err
+	}
+	if value > int(maxValue) {
+		return 0, tr.errorf(ErrSampleOutOfRange, "sample %d exceeds max value %d", value, maxValue)
+	}
+	return uint16(value), nil
+}
+
+// raster reads a raw raster of size bytes. The buffer grows as the data
+// arrives, so a header claiming a huge image cannot make the reader
+// allocate much more than the stream actually holds.
+func (tr *tokenReader) raster(size int) ([]byte, error) {
+	data := make([]byte, 0, min(size, 1<<20))
+	for len(data) < size {
+		if len(data) == cap(data) {
+			grown := make([]byte, len(data), min(2*cap(data), size))
+			copy(grown, data)
+			data = grown
+		}
+		n, err := io.ReadFull(tr, data[len(data):cap(data)])
+		data = data[:len(data)+n]
+		if err != nil {
+			return nil, tr.readError(err, "raster")
+		}
+	}
+	return data, nil
+}
+
+// checkSamples checks every sample of data, the raw raster just read,
+// against maxValue.
+func (tr *tokenReader) checkSamples(data []byte, maxValue uint16) error {
+	sampleSize := bytesPerSample(maxValue)
+	if maxValue == 255 || maxValue == 65535 {
+		return nil
+	}
+	for i := 0; i < len(data)/sampleSize; i++ {
+		if value := getSample(data, i, sampleSize); value > maxValue {
+			offset := tr.offset - int64(len(data)-i*sampleSize)
+			return &ParseError{
+				Format: tr.format,
+				Offset: offset,
+				Reason: fmt.Sprintf("sample %d exceeds max value %d", value, maxValue),
+				Err:    ErrSampleOutOfRange,
+			}
+		}
+	}
+	return nil
+}
+
+func (tr *tokenReader) dimensions() (int, int, error) {
+	width, err := tr.int("width")
+	if err != nil {
+		return 0, 0, err
+	}
+	height, err := tr.int("height")
+	if err != nil {
+		return 0, 0, err
+	}
+	if width <= 0 || height <= 0 {
+		return 0, 0, tr.errorf(ErrBadDimensions, "invalid dimensions %dx%d", width, height)
+	}
+	return width, height, nil
+}
+
+// checkRasterSize rejects a width x height image whose raster, of height
+// rows of the product of rowFactors bytes, would not fit in memory.
+func (tr *tokenReader) checkRasterSize(width, height int, rowFactors ...int) error {
+	size := height
+	for _, factor := range rowFactors {
+		if factor > math.MaxInt/size {
+			return tr.errorf(ErrTooLarge, "%dx%d image is too large", width, height)
+		}
+		size *= factor
+	}
+	return nil
+}
+
+func (tr *tokenReader) maxValue() (uint16, error) {
+	maxValue, err := tr.int("max value")
+	if err != nil {
+		return 0, err
+	}
+	if maxValue <= 0 || maxValue > 65535 {
+		return 0, tr.errorf(ErrBadMaxValue, "invalid max value %d", maxValue)
+	}
+	return uint16(maxValue), nil
+}
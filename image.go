@@ -0,0 +1,106 @@
+package Netpbm
+
+import (
+	"image"
+	"image/color"
+	"io"
+)
+
+func init() {
+	image.RegisterFormat("pbm", "P1", decodeImagePBM, decodeConfig)
+	image.RegisterFormat("pbm", "P4", decodeImagePBM, decodeConfig)
+	image.RegisterFormat("pgm", "P2", decodeImagePGM, decodeConfig)
+	image.RegisterFormat("pgm", "P5", decodeImagePGM, decodeConfig)
+	image.RegisterFormat("ppm", "P3", decodeImagePPM, decodeConfig)
+	image.RegisterFormat("ppm", "P6", decodeImagePPM, decodeConfig)
+	image.RegisterFormat("pam", "P7", decodeImagePAM, decodeConfigPAM)
+}
+
+func decodeImagePBM(r io.Reader) (image.Image, error) {
+	return DecodePBM(r)
+}
+
+func decodeImagePGM(r io.Reader) (image.Image, error) {
+	return DecodePGM(r)
+}
+
+func decodeImagePPM(r io.Reader) (image.Image, error) {
+	return DecodePPM(r)
+}
+
+func decodeImagePAM(r io.Reader) (image.Image, error) {
+	return DecodePAM(r)
+}
+
+func decodeConfigPAM(r io.Reader) (image.Config, error) {
+	tr := newTokenReader(r, "PAM")
+
+	magicNumber, err := tr.magic()
+	if err != nil {
+		return image.Config{}, err
+	}
+	if magicNumber != "P7" {
+		return image.Config{}, tr.errorf(ErrBadMagic, "invalid PAM magic number %q", magicNumber)
+	}
+
+	width, height, depth, maxValue, _, err := readPAMHeader(tr)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	pam := &PAM{depth: depth, max: maxValue}
+	return image.Config{ColorModel: pam.ColorModel(), Width: width, Height: height}, nil
+}
+
+func decodeConfig(r io.Reader) (image.Config, error) {
+	header, err := DecodeHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	var model color.Model
+	switch header.MagicNumber {
+	case "P1", "P4":
+		model = pbmPalette
+	case "P2", "P5":
+		model = color.GrayModel
+		if header.MaxValue > 255 {
+			model = color.Gray16Model
+		}
+	default:
+		model = color.RGBAModel
+		if header.MaxValue > 255 {
+			model = color.RGBA64Model
+		}
+	}
+
+	return image.Config{ColorModel: model, Width: header.Width, Height: header.Height}, nil
+}
+
+// scaleSample rescales a sample in the range [0, from] to the range [0, to],
+// rounding to the nearest value.
+func scaleSample(value, from, to uint16) uint16 {
+	if value >= from {
+		return to
+	}
+	return uint16((uint32(value)*uint32(to) + uint32(from)/2) / uint32(from))
+}
+
+// invertSample returns maxValue-value, treating values above maxValue as
+// maxValue.
+func invertSample(value, maxValue uint16) uint16 {
+	if value > maxValue {
+		return 0
+	}
+	return maxValue - value
+}
+
+// isDark reports whether value lies in the darker half of [0, maxValue],
+// that is below maxValue/2 without rounding the midpoint.
+func isDark(value, maxValue uint32) bool {
+	return 2*value < maxValue
+}
+
+func is16BitModel(model color.Model) bool {
+	return model == color.Gray16Model || model == color.RGBA64Model || model == color.NRGBA64Model
+}
@@ -0,0 +1,156 @@
+package Netpbm
+
+import (
+	"bufio"
+	"image"
+	"io"
+	"os"
+)
+
+// PNM is implemented by PBM, PGM and PPM.
+type PNM interface {
+	image.Image
+	Size() (int, int)
+	MagicNumber() string
+	SetMagicNumber(magicNumber string)
+	Save(filename string) error
+	Encode(w io.Writer) error
+	Invert()
+	Flip()
+	Flop()
+	Rotate90CW()
+	// Clone returns a copy of the image with the same bounds and its own
+	// memory, of the same concrete type.
+	Clone() PNM
+}
+
+func Rotate180(img PNM) {
+	img.Flip()
+	img.Flop()
+}
+
+func Rotate90CCW(img PNM) {
+	img.Rotate90CW()
+	Rotate180(img)
+}
+
+// Transpose mirrors img across its top-left to bottom-right diagonal.
+func Transpose(img PNM) {
+	img.Rotate90CW()
+	img.Flip()
+}
+
+// Transformed applies transform to a clone of img and returns the clone,
+// leaving img unchanged:
+//
+//	flipped := Transformed(pgm, PNM.Flip)
+func Transformed[T PNM](img T, transform func(PNM)) T {
+	clone := img.Clone().(T)
+	transform(clone)
+	return clone
+}
+
+// ReadAny reads a PBM, PGM or PPM file, detecting the format from its
+// magic number.
+func ReadAny(filename string) (PNM, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Decode(file)
+}
+
+func Decode(r io.Reader) (PNM, error) {
+	return (*DecodeOptions)(nil).Decode(r)
+}
+
+func newReader(r io.Reader) *bufio.Reader {
+	if br, ok := r.(*bufio.Reader); ok {
+		return br
+	}
+	return bufio.NewReader(r)
+}
+
+// bytesPerSample returns the size of a raw sample: samples with a max value
+// above 255 are stored as two bytes, most significant byte first.
+func bytesPerSample(maxValue uint16) int {
+	if maxValue > 255 {
+		return 2
+	}
+	return 1
+}
+
+func getSample(data []byte, i, size int) uint16 {
+	if size == 2 {
+		return uint16(data[i*2])<<8 | uint16(data[i*2+1])
+	}
+	return uint16(data[i])
+}
+
+func appendSample(data []byte, size int, value uint16) []byte {
+	if size == 2 {
+		return append(data, byte(value>>8), byte(value))
+	}
+	return append(data, byte(value))
+}
+
+func putSample(data []byte, i, size int, value uint16) {
+	if size == 2 {
+		data[i*2] = byte(value >> 8)
+		data[i*2+1] = byte(value)
+	} else {
+		data[i] = byte(value)
+	}
+}
+
+// samples is memory laid out like a raw PGM or PPM raster: rows stride
+// bytes apart, each pixel channels samples of size bytes.
+type samples struct {
+	pix      []uint8
+	stride   int
+	channels int
+	size     int
+}
+
+func newSamples(width, height, channels, size int) samples {
+	return samplesOf(make([]uint8, width*channels*size*height), width, channels, size)
+}
+
+// samplesOf returns samples backed by pix, a raw raster of rows of width
+// pixels.
+func samplesOf(pix []uint8, width, channels, size int) samples {
+	return samples{pix: pix, stride: width * channels * size, channels: channels, size: size}
+}
+
+// row returns the encoded samples of the first width pixels of row y.
+func (s samples) row(y, width int) []uint8 {
+	start := y * s.stride
+	return s.pix[start : start+width*s.channels*s.size]
+}
+
+func (s samples) subSamples(x, y int) samples {
+	s.pix = s.pix[y*s.stride+x*s.channels*s.size:]
+	return s
+}
+
+func (s samples) swapRows(y1, y2, width int) {
+	row1, row2 := s.row(y1, width), s.row(y2, width)
+	for i := range row1 {
+		row1[i], row2[i] = row2[i], row1[i]
+	}
+}
+
+func createAndEncode(filename string, encode func(io.Writer) error) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	err = encode(file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,45 @@
+package Netpbm
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// limitedWriter accepts n bytes, then fails with io.ErrShortWrite.
+type limitedWriter struct {
+	n int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		written := w.n
+		w.n = 0
+		return written, io.ErrShortWrite
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestEncodeWriteErrors(t *testing.T) {
+	// The raw PGM header "P5\n4 4\n255\n" is 11 bytes long.
+	tests := []struct {
+		limit int
+		label string
+	}{
+		{0, "error writing header"},
+		{5, "error writing header"},
+		{11, "error writing pixel data"},
+		{20, "error writing pixel data"},
+	}
+	for _, test := range tests {
+		err := EncodePGM(&limitedWriter{n: test.limit}, NewPGM(4, 4, 255))
+		if !errors.Is(err, io.ErrShortWrite) {
+			t.Errorf("limit %d: got %v, want it to wrap %v", test.limit, err, io.ErrShortWrite)
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), test.label) {
+			t.Errorf("limit %d: got %v, want %q", test.limit, err, test.label)
+		}
+	}
+}
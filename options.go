@@ -0,0 +1,242 @@
+package Netpbm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DecodeOptions limits the resources a decoder may use, so that untrusted
+// input cannot make it allocate arbitrary amounts of memory. Limits are
+// checked against the header before the raster is read. A zero field means
+// no limit; a nil *DecodeOptions imposes no limits at all.
+//
+// Even without limits, the raster is allocated as its data arrives rather
+// than from the header, so memory use is bounded by the size of the input.
+type DecodeOptions struct {
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int64
+	// MaxBytes bounds the number of bytes read for one image, header
+	// included.
+	MaxBytes int64
+}
+
+func (o *DecodeOptions) newTokenReader(r io.Reader, format string) *tokenReader {
+	tr := newTokenReader(r, format)
+	if o != nil {
+		tr.limit = o.MaxBytes
+	}
+	return tr
+}
+
+// checkLimits rejects an image whose header exceeds the limits. rasterSize
+// is the smallest number of bytes the raster can occupy.
+func (o *DecodeOptions) checkLimits(tr *tokenReader, width, height int, rasterSize int64) error {
+	if o == nil {
+		return nil
+	}
+	if o.MaxWidth > 0 && width > o.MaxWidth {
+		return tr.errorf(ErrTooLarge, "width %d exceeds limit %d", width, o.MaxWidth)
+	}
+	if o.MaxHeight > 0 && height > o.MaxHeight {
+		return tr.errorf(ErrTooLarge, "height %d exceeds limit %d", height, o.MaxHeight)
+	}
+	if pixels := int64(width) * int64(height); o.MaxPixels > 0 && pixels > o.MaxPixels {
+		return tr.errorf(ErrTooLarge, "%d pixels exceed limit %d", pixels, o.MaxPixels)
+	}
+	if o.MaxBytes > 0 && tr.offset+rasterSize > o.MaxBytes {
+		return tr.errorf(ErrTooLarge, "%d byte raster exceeds limit %d", rasterSize, o.MaxBytes)
+	}
+	return nil
+}
+
+func (o *DecodeOptions) DecodePBM(r io.Reader) (*PBM, error) {
+	return decodePBM(o.newTokenReader(r, "PBM"), o)
+}
+
+func (o *DecodeOptions) DecodePGM(r io.Reader) (*PGM, error) {
+	return decodePGM(o.newTokenReader(r, "PGM"), o)
+}
+
+func (o *DecodeOptions) DecodePPM(r io.Reader) (*PPM, error) {
+	return decodePPM(o.newTokenReader(r, "PPM"), o)
+}
+
+func (o *DecodeOptions) DecodePAM(r io.Reader) (*PAM, error) {
+	return decodePAM(o.newTokenReader(r, "PAM"), o)
+}
+
+func (o *DecodeOptions) DecodePFM(r io.Reader) (*PFM, error) {
+	return decodePFM(o.newTokenReader(r, "PFM"), o)
+}
+
+// Decode reads a PBM, PGM or PPM image, detecting the format from its magic
+// number.
+func (o *DecodeOptions) Decode(r io.Reader) (PNM, error) {
+	br := newReader(r)
+
+	magic, err := br.Peek(2)
+	var img PNM
+	switch formatName(string(magic)) {
+	case "PBM":
+		img, err = o.DecodePBM(br)
+	case "PGM":
+		img, err = o.DecodePGM(br)
+	case "PPM":
+		img, err = o.DecodePPM(br)
+	default:
+		tr := newTokenReader(br, "")
+		if err != nil {
+			return nil, tr.readError(err, "magic number")
+		}
+		return nil, tr.errorf(ErrBadMagic, "unknown magic number %q", magic)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// Encoding selects between the plain (ASCII) and raw (binary) variants of a
+// format.
+type Encoding int
+
+const (
+	// EncodingDefault follows the image's magic number.
+	EncodingDefault Encoding = iota
+	EncodingPlain
+	EncodingRaw
+)
+
+// EncodeOptions controls how images are written. The zero value, like a nil
+// *EncodeOptions, writes the format given by the image's magic number with
+// plain lines wrapped at 70 characters.
+type EncodeOptions struct {
+	Encoding Encoding
+	// MaxLineLength is the longest line a plain raster may contain. Zero
+	// means 70, the limit other Netpbm tools rely on; a negative value
+	// disables wrapping. Each image row starts on a new line.
+	MaxLineLength int
+	// Separator is written between plain samples and may only contain
+	// whitespace. When empty, plain PBM pixels are packed together ("0110")
+	// and other formats use a space.
+	Separator string
+	// Comments are written to the header after the image's own comments,
+	// one '#' line per line of each comment.
+	Comments []string
+}
+
+func (o *EncodeOptions) EncodePBM(w io.Writer, pbm *PBM) error {
+	return encodePBM(w, pbm, o)
+}
+
+func (o *EncodeOptions) EncodePGM(w io.Writer, pgm *PGM) error {
+	return encodePGM(w, pgm, o)
+}
+
+func (o *EncodeOptions) EncodePPM(w io.Writer, ppm *PPM) error {
+	return encodePPM(w, ppm, o)
+}
+
+// Encode writes a PBM, PGM or PPM image.
+func (o *EncodeOptions) Encode(w io.Writer, img PNM) error {
+	switch img := img.(type) {
+	case *PBM:
+		return encodePBM(w, img, o)
+	case *PGM:
+		return encodePGM(w, img, o)
+	case *PPM:
+		return encodePPM(w, img, o)
+	}
+	return fmt.Errorf("unsupported image type %T", img)
+}
+
+func (o *EncodeOptions) Save(filename string, img PNM) error {
+	return createAndEncode(filename, func(w io.Writer) error {
+		return o.Encode(w, img)
+	})
+}
+
+// check rejects options that would produce an unreadable image.
+func (o *EncodeOptions) check() error {
+	if o == nil {
+		return nil
+	}
+	for i := 0; i < len(o.Separator); i++ {
+		if !isSpace(o.Separator[i]) {
+			return fmt.Errorf("invalid separator %q: only whitespace may separate samples", o.Separator)
+		}
+	}
+	return nil
+}
+
+// magicNumber returns the magic number to write for an image whose own
+// magic number is magicNumber.
+func (o *EncodeOptions) magicNumber(magicNumber string) string {
+	if o == nil {
+		return magicNumber
+	}
+	switch {
+	case o.Encoding == EncodingPlain && magicNumber >= "P4":
+		return string([]byte{'P', magicNumber[1] - 3})
+	case o.Encoding == EncodingRaw && magicNumber <= "P3":
+		return string([]byte{'P', magicNumber[1] + 3})
+	}
+	return magicNumber
+}
+
+func (o *EncodeOptions) writeHeader(writer *bufio.Writer, magicNumber string, comments []string) {
+	writer.WriteString(magicNumber + "\n")
+	writeComments(writer, comments)
+	if o != nil {
+		writeComments(writer, o.Comments)
+	}
+}
+
+func (o *EncodeOptions) plainWriter(writer *bufio.Writer, defaultSeparator string) *plainWriter {
+	plain := &plainWriter{writer: writer, separator: defaultSeparator, maxLineLength: 70}
+	if o != nil {
+		if o.Separator != "" {
+			plain.separator = o.Separator
+		}
+		if o.MaxLineLength != 0 {
+			plain.maxLineLength = o.MaxLineLength
+		}
+	}
+	return plain
+}
+
+// plainWriter writes the tokens of a plain raster, breaking lines so that
+// none exceeds maxLineLength.
+type plainWriter struct {
+	writer        *bufio.Writer
+	separator     string
+	maxLineLength int
+	lineLength    int
+}
+
+func (p *plainWriter) write(token string) {
+	if p.lineLength > 0 {
+		if p.maxLineLength > 0 && p.lineLength+len(p.separator)+len(token) > p.maxLineLength {
+			p.writer.WriteByte('\n')
+			p.lineLength = 0
+		} else {
+			p.writer.WriteString(p.separator)
+			if i := strings.LastIndexByte(p.separator, '\n'); i >= 0 {
+				p.lineLength = len(p.separator) - i - 1
+			} else {
+				p.lineLength += len(p.separator)
+			}
+		}
+	}
+	p.writer.WriteString(token)
+	p.lineLength += len(token)
+}
+
+func (p *plainWriter) endRow() {
+	p.writer.WriteByte('\n')
+	p.lineLength = 0
+}
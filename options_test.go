@@ -0,0 +1,58 @@
+package Netpbm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncodeSeparator(t *testing.T) {
+	ppm := NewPPM(8, 2, 255)
+	ppm.Set(3, 1, Pixel{R: 200, G: 100, B: 50})
+	ppm.SetMagicNumber("P3")
+
+	if err := (&EncodeOptions{Separator: ","}).Encode(new(bytes.Buffer), ppm); err == nil {
+		t.Error("separator \",\" accepted")
+	}
+
+	for _, separator := range []string{"\t", "  ", "\n", " \n "} {
+		var buf bytes.Buffer
+		opts := &EncodeOptions{Separator: separator, MaxLineLength: 12}
+		if err := opts.Encode(&buf, ppm); err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if len(line) > 12 {
+				t.Errorf("separator %q: line %q exceeds 12 characters", separator, line)
+			}
+		}
+		decoded, err := DecodePPM(&buf)
+		if err != nil {
+			t.Fatalf("separator %q: %v", separator, err)
+		}
+		if decoded.PixelAt(3, 1) != ppm.PixelAt(3, 1) {
+			t.Errorf("separator %q: got %v, want %v", separator, decoded.PixelAt(3, 1), ppm.PixelAt(3, 1))
+		}
+	}
+}
+
+func TestEncodeCommentLineBreaks(t *testing.T) {
+	pgm := NewPGM(2, 2, 255)
+	pgm.Set(1, 1, 200)
+	opts := &EncodeOptions{Comments: []string{"hello\n5 5", "a\rb\r\nc"}}
+	var buf bytes.Buffer
+	if err := opts.Encode(&buf, pgm); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodePGM(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h := decoded.Size(); w != 2 || h != 2 || decoded.GrayAt(1, 1) != 200 {
+		t.Fatalf("got %dx%d image with %d at (1, 1)", w, h, decoded.GrayAt(1, 1))
+	}
+	want := []string{"hello", "5 5", "a", "b", "c"}
+	if got := decoded.Comments(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got comments %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,387 @@
+package Netpbm
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+const (
+	TupleTypeBlackAndWhite  = "BLACKANDWHITE"
+	TupleTypeGrayscale      = "GRAYSCALE"
+	TupleTypeRGB            = "RGB"
+	TupleTypeGrayscaleAlpha = "GRAYSCALE_ALPHA"
+	TupleTypeRGBAlpha       = "RGB_ALPHA"
+)
+
+// PAM is a P7 image. Each row holds width*depth samples, tuple by tuple.
+type PAM struct {
+	data      [][]uint16
+	width     int
+	height    int
+	depth     int
+	max       uint16
+	tupleType string
+}
+
+func tupleDepth(tupleType string) int {
+	switch tupleType {
+	case TupleTypeBlackAndWhite, TupleTypeGrayscale:
+		return 1
+	case TupleTypeGrayscaleAlpha:
+		return 2
+	case TupleTypeRGB:
+		return 3
+	case TupleTypeRGBAlpha:
+		return 4
+	}
+	return 0
+}
+
+// NewPAM returns a PAM of one of the standard tuple types, whose depth
+// follows from the type.
+func NewPAM(width, height int, tupleType string, maxValue uint16) (*PAM, error) {
+	depth := tupleDepth(tupleType)
+	if depth == 0 {
+		return nil, fmt.Errorf("unknown PAM tuple type %q", tupleType)
+	}
+	return newPAM(width, height, depth, tupleType, maxValue), nil
+}
+
+func newPAM(width, height, depth int, tupleType string, maxValue uint16) *PAM {
+	pam := &PAM{
+		data:      make([][]uint16, height),
+		width:     width,
+		height:    height,
+		depth:     depth,
+		max:       maxValue,
+		tupleType: tupleType,
+	}
+	for y := range pam.data {
+		pam.data[y] = make([]uint16, width*depth)
+	}
+	return pam
+}
+
+func NewPAMFromImage(img image.Image) *PAM {
+	bounds := img.Bounds()
+	var maxValue uint16 = 255
+	if is16BitModel(img.ColorModel()) {
+		maxValue = 65535
+	}
+	pam := newPAM(bounds.Dx(), bounds.Dy(), 4, TupleTypeRGBAlpha, maxValue)
+	for y := 0; y < pam.height; y++ {
+		for x := 0; x < pam.width; x++ {
+			c := color.NRGBA64Model.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA64)
+			pam.SetTuple(x, y, []uint16{
+				scaleSample(c.R, 65535, maxValue),
+				scaleSample(c.G, 65535, maxValue),
+				scaleSample(c.B, 65535, maxValue),
+				scaleSample(c.A, 65535, maxValue),
+			})
+		}
+	}
+	return pam
+}
+
+func ReadPAM(filename string) (*PAM, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return DecodePAM(file)
+}
+
+func DecodePAM(r io.Reader) (*PAM, error) {
+	return decodePAM(newTokenReader(r, "PAM"), nil)
+}
+
+func decodePAM(tr *tokenReader, opts *DecodeOptions) (*PAM, error) {
+	magicNumber, err := tr.magic()
+	if err != nil {
+		return nil, err
+	}
+	if magicNumber != "P7" {
+		return nil, tr.errorf(ErrBadMagic, "invalid PAM magic number %q", magicNumber)
+	}
+
+	width, height, depth, maxValue, tupleType, err := readPAMHeader(tr)
+	if err != nil {
+		return nil, err
+	}
+	rasterSize := int64(width) * int64(height) * int64(depth) * int64(bytesPerSample(maxValue))
+	if err := opts.checkLimits(tr, width, height, rasterSize); err != nil {
+		return nil, err
+	}
+
+	tr.startRaw()
+	sampleSize := bytesPerSample(maxValue)
+	data, err := tr.raster(width * height * depth * sampleSize)
+	if err != nil {
+		return nil, err
+	}
+	if err := tr.checkSamples(data, maxValue); err != nil {
+		return nil, err
+	}
+
+	pam := &PAM{
+		data:      make([][]uint16, height),
+		width:     width,
+		height:    height,
+		depth:     depth,
+		max:       maxValue,
+		tupleType: tupleType,
+	}
+	rowSize := width * depth * sampleSize
+	for y := range pam.data {
+		rowData := data[y*rowSize:]
+		pam.data[y] = make([]uint16, width*depth)
+		for i := range pam.data[y] {
+			pam.data[y][i] = getSample(rowData, i, sampleSize)
+		}
+	}
+
+	return pam, nil
+}
+
+func readPAMHeader(tr *tokenReader) (width, height, depth int, maxValue uint16, tupleType string, err error) {
+	var tupleTypes []string
+	maxVal := 0
+	for {
+		tr.mark()
+		line, err := tr.readLine()
+		if err != nil {
+			return 0, 0, 0, 0, "", tr.readError(err, "header")
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		keyword := strings.Fields(line)[0]
+		value := strings.TrimSpace(line[len(keyword):])
+
+		var target *int
+		switch keyword {
+		case "ENDHDR":
+			if width <= 0 || height <= 0 || depth <= 0 || int64(width)*int64(depth) > math.MaxInt32 {
+				return 0, 0, 0, 0, "", tr.errorf(ErrBadDimensions, "invalid dimensions %dx%dx%d", width, height, depth)
+			}
+			if maxVal <= 0 || maxVal > 65535 {
+				return 0, 0, 0, 0, "", tr.errorf(ErrBadMaxValue, "invalid max value %d", maxVal)
+			}
+			// Samples are kept as uint16 whatever the maxval.
+			if err := tr.checkRasterSize(width, height, width, depth, 2); err != nil {
+				return 0, 0, 0, 0, "", err
+			}
+			tupleType = strings.Join(tupleTypes, " ")
+			if expected := tupleDepth(tupleType); expected != 0 && expected != depth {
+				return 0, 0, 0, 0, "", tr.errorf(ErrBadDimensions, "tuple type %s requires depth %d, got %d", tupleType, expected, depth)
+			}
+			return width, height, depth, uint16(maxVal), tupleType, nil
+		case "WIDTH":
+			target = &width
+		case "HEIGHT":
+			target = &height
+		case "DEPTH":
+			target = &depth
+		case "MAXVAL":
+			target = &maxVal
+		case "TUPLTYPE":
+			tupleTypes = append(tupleTypes, value)
+			continue
+		default:
+			return 0, 0, 0, 0, "", tr.errorf(ErrSyntax, "unknown header keyword %q", keyword)
+		}
+
+		var ok bool
+		if *target, ok = parseUint(value); !ok {
+			return 0, 0, 0, 0, "", tr.errorf(ErrSyntax, "invalid %s value %q", keyword, value)
+		}
+	}
+}
+
+func (pam *PAM) Save(filename string) error {
+	return createAndEncode(filename, func(w io.Writer) error {
+		return EncodePAM(w, pam)
+	})
+}
+
+func EncodePAM(w io.Writer, pam *PAM) error {
+	writer := bufio.NewWriter(w)
+	fmt.Fprint(writer, "P7\n")
+	fmt.Fprintf(writer, "WIDTH %d\n", pam.width)
+	fmt.Fprintf(writer, "HEIGHT %d\n", pam.height)
+	fmt.Fprintf(writer, "DEPTH %d\n", pam.depth)
+	fmt.Fprintf(writer, "MAXVAL %d\n", pam.max)
+	if pam.tupleType != "" {
+		fmt.Fprintf(writer, "TUPLTYPE %s\n", pam.tupleType)
+	}
+	fmt.Fprint(writer, "ENDHDR\n")
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing header: %w", err)
+	}
+
+	sampleSize := bytesPerSample(pam.max)
+	rowData := make([]byte, pam.width*pam.depth*sampleSize)
+	for _, row := range pam.data {
+		for i, sample := range row {
+			putSample(rowData, i, sampleSize, sample)
+		}
+		if _, err := writer.Write(rowData); err != nil {
+			return fmt.Errorf("error writing pixel data: %w", err)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing pixel data: %w", err)
+	}
+	return nil
+}
+
+func (pam *PAM) Size() (int, int) {
+	return pam.width, pam.height
+}
+
+func (pam *PAM) Depth() int {
+	return pam.depth
+}
+
+func (pam *PAM) MaxValue() uint16 {
+	return pam.max
+}
+
+func (pam *PAM) TupleType() string {
+	return pam.tupleType
+}
+
+func (pam *PAM) Tuple(x, y int) []uint16 {
+	return pam.data[y][x*pam.depth : (x+1)*pam.depth]
+}
+
+func (pam *PAM) SetTuple(x, y int, tuple []uint16) {
+	copy(pam.data[y][x*pam.depth:(x+1)*pam.depth], tuple)
+}
+
+func (pam *PAM) ColorModel() color.Model {
+	wide := pam.max > 255
+	switch pam.depth {
+	case 2, 4:
+		if wide {
+			return color.NRGBA64Model
+		}
+		return color.NRGBAModel
+	case 3:
+		if wide {
+			return color.RGBA64Model
+		}
+		return color.RGBAModel
+	}
+	if wide {
+		return color.Gray16Model
+	}
+	return color.GrayModel
+}
+
+func (pam *PAM) Bounds() image.Rectangle {
+	return image.Rect(0, 0, pam.width, pam.height)
+}
+
+func (pam *PAM) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(pam.Bounds())) {
+		return color.Gray{}
+	}
+
+	tuple := pam.Tuple(x, y)
+	scale := func(value uint16) uint16 {
+		return scaleSample(value, pam.max, 65535)
+	}
+	var c color.NRGBA64
+	switch pam.depth {
+	case 2:
+		c = color.NRGBA64{R: scale(tuple[0]), G: scale(tuple[0]), B: scale(tuple[0]), A: scale(tuple[1])}
+	case 3:
+		c = color.NRGBA64{R: scale(tuple[0]), G: scale(tuple[1]), B: scale(tuple[2]), A: 65535}
+	case 4:
+		c = color.NRGBA64{R: scale(tuple[0]), G: scale(tuple[1]), B: scale(tuple[2]), A: scale(tuple[3])}
+	default:
+		c = color.NRGBA64{R: scale(tuple[0]), G: scale(tuple[0]), B: scale(tuple[0]), A: 65535}
+	}
+	return pam.ColorModel().Convert(c)
+}
+
+// checkConversion returns an error unless the PAM has one of tupleTypes.
+func (pam *PAM) checkConversion(format string, tupleTypes ...string) error {
+	for _, tupleType := range tupleTypes {
+		if pam.tupleType == tupleType {
+			return nil
+		}
+	}
+	if pam.tupleType == TupleTypeGrayscaleAlpha || pam.tupleType == TupleTypeRGBAlpha {
+		return fmt.Errorf("can't convert %s PAM to %s without losing its alpha channel", pam.tupleType, format)
+	}
+	return fmt.Errorf("can't convert %s PAM to %s", pam.tupleType, format)
+}
+
+// ToPBM converts a BLACKANDWHITE PAM, whose maxval must be 1, to a PBM.
+func (pam *PAM) ToPBM() (*PBM, error) {
+	if err := pam.checkConversion("PBM", TupleTypeBlackAndWhite); err != nil {
+		return nil, err
+	}
+	if pam.max != 1 {
+		return nil, fmt.Errorf("can't convert %s PAM with max value %d to PBM", pam.tupleType, pam.max)
+	}
+
+	pbm := NewPBM(pam.width, pam.height)
+	for y, row := range pam.data {
+		for x, sample := range row {
+			pbm.Set(x, y, sample == 0)
+		}
+	}
+	return pbm, nil
+}
+
+// ToPGM converts a BLACKANDWHITE or GRAYSCALE PAM to a PGM. PAMs with an
+// alpha channel are rejected rather than losing it.
+func (pam *PAM) ToPGM() (*PGM, error) {
+	if err := pam.checkConversion("PGM", TupleTypeBlackAndWhite, TupleTypeGrayscale); err != nil {
+		return nil, err
+	}
+
+	pgm := NewPGM(pam.width, pam.height, pam.max)
+	for y, row := range pam.data {
+		for x := 0; x < pam.width; x++ {
+			pgm.Set(x, y, row[x*pam.depth])
+		}
+	}
+	return pgm, nil
+}
+
+// ToPPM converts a BLACKANDWHITE, GRAYSCALE or RGB PAM to a PPM. PAMs with
+// an alpha channel are rejected rather than losing it.
+func (pam *PAM) ToPPM() (*PPM, error) {
+	if err := pam.checkConversion("PPM", TupleTypeBlackAndWhite, TupleTypeGrayscale, TupleTypeRGB); err != nil {
+		return nil, err
+	}
+
+	ppm := NewPPM(pam.width, pam.height, pam.max)
+	for y, row := range pam.data {
+		for x := 0; x < pam.width; x++ {
+			tuple := row[x*pam.depth : (x+1)*pam.depth]
+			if pam.depth < 3 {
+				ppm.Set(x, y, Pixel{R: tuple[0], G: tuple[0], B: tuple[0]})
+			} else {
+				ppm.Set(x, y, Pixel{R: tuple[0], G: tuple[1], B: tuple[2]})
+			}
+		}
+	}
+	return ppm, nil
+}
@@ -0,0 +1,77 @@
+package Netpbm
+
+import (
+	"bytes"
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestDecodePAMHeaderWhitespace(t *testing.T) {
+	src := "P7\nWIDTH\t2\nHEIGHT  1\nDEPTH 1\nMAXVAL 255\nTUPLTYPE\tGRAYSCALE\nENDHDR\n\x01\x02"
+
+	pam, err := DecodePAM(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pam.TupleType() != TupleTypeGrayscale || pam.Tuple(1, 0)[0] != 2 {
+		t.Errorf("got tuple type %q and sample %d", pam.TupleType(), pam.Tuple(1, 0)[0])
+	}
+
+	config, _, err := image.DecodeConfig(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 2 || config.Height != 1 {
+		t.Errorf("got %dx%d config", config.Width, config.Height)
+	}
+}
+
+func TestNewPAM(t *testing.T) {
+	if _, err := NewPAM(1, 1, "FOO", 255); err == nil {
+		t.Error("unknown tuple type accepted")
+	}
+
+	pam, err := NewPAM(2, 2, TupleTypeRGB, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := EncodePAM(&buf, pam); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodePAM(&buf); err != nil {
+		t.Errorf("can't read back NewPAM output: %v", err)
+	}
+}
+
+func TestPAMConversions(t *testing.T) {
+	for _, test := range []struct {
+		tupleType string
+		maxValue  uint16
+		pbm       bool
+		pgm       bool
+		ppm       bool
+	}{
+		{TupleTypeBlackAndWhite, 1, true, true, true},
+		{TupleTypeBlackAndWhite, 255, false, true, true},
+		{TupleTypeGrayscale, 255, false, true, true},
+		{TupleTypeRGB, 255, false, false, true},
+		{TupleTypeGrayscaleAlpha, 255, false, false, false},
+		{TupleTypeRGBAlpha, 255, false, false, false},
+	} {
+		pam, err := NewPAM(2, 2, test.tupleType, test.maxValue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pam.ToPBM(); (err == nil) != test.pbm {
+			t.Errorf("%s/%d to PBM: got error %v", test.tupleType, test.maxValue, err)
+		}
+		if _, err := pam.ToPGM(); (err == nil) != test.pgm {
+			t.Errorf("%s/%d to PGM: got error %v", test.tupleType, test.maxValue, err)
+		}
+		if _, err := pam.ToPPM(); (err == nil) != test.ppm {
+			t.Errorf("%s/%d to PPM: got error %v", test.tupleType, test.maxValue, err)
+		}
+	}
+}
@@ -2,16 +2,161 @@ package Netpbm
 
 import (
 	"bufio"
+	"encoding/binary"
 	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math/bits"
 	"os"
-	"strings"
 )
 
+var pbmPalette = color.Palette{color.Black, color.White}
+
+// PBM is an Image[bool] whose pixels are packed into uint64 words, most
+// significant bit first. A set bit is black, as in P4.
 type PBM struct {
-	data        [][]bool
-	width       int
-	height      int
+	Image[bool]
 	magicNumber string
+	comments
+}
+
+func NewPBM(width, height int) *PBM {
+	return newPBM(width, height, newBitStore(width, height))
+}
+
+func newPBM(width, height int, s bitStore) *PBM {
+	return &PBM{
+		Image: Image[bool]{
+			store: s,
+			rect:  image.Rect(0, 0, width, height),
+		},
+		magicNumber: "P4",
+	}
+}
+
+// bitStore keeps rows stride words apart. Pixel x of a row is bit offset+x
+// counted from the top of the row's first word; offset is zero except in
+// SubImage views, and bits past the last pixel of a row are zero unless
+// they belong to the parent of a view.
+type bitStore struct {
+	words  []uint64
+	stride int
+	offset int
+}
+
+func newBitStore(width, height int) bitStore {
+	stride := (width + 63) / 64
+	return bitStore{words: make([]uint64, stride*height), stride: stride}
+}
+
+// bitStoreOf returns a bitStore holding data, a raw P4 raster.
+func bitStoreOf(data []byte, width, height int) bitStore {
+	s := newBitStore(width, height)
+	rowSize := (width + 7) / 8
+	row := make([]uint64, s.stride)
+	rowData := make([]byte, len(row)*8)
+	for y := 0; y < height; y++ {
+		copy(rowData, data[y*rowSize:(y+1)*rowSize])
+		for i := range row {
+			row[i] = binary.BigEndian.Uint64(rowData[i*8:])
+		}
+		s.storeRow(y, width, row)
+	}
+	return s
+}
+
+// row returns the words holding the first width pixels of row y.
+func (s bitStore) row(y, width int) []uint64 {
+	start := y * s.stride
+	return s.words[start : start+(s.offset+width+63)/64]
+}
+
+func (s bitStore) at(x, y int) bool {
+	bit := s.offset + x
+	return s.words[y*s.stride+bit/64]&(1<<(63-bit%64)) != 0
+}
+
+func (s bitStore) set(x, y int, value bool) {
+	bit := s.offset + x
+	if value {
+		s.words[y*s.stride+bit/64] |= 1 << (63 - bit%64)
+	} else {
+		s.words[y*s.stride+bit/64] &^= 1 << (63 - bit%64)
+	}
+}
+
+func (s bitStore) sub(x, y int) store[bool] {
+	bit := s.offset + x
+	return bitStore{words: s.words[y*s.stride+bit/64:], stride: s.stride, offset: bit % 64}
+}
+
+func (s bitStore) make(width, height int) store[bool] {
+	return newBitStore(width, height)
+}
+
+func (s bitStore) swapRows(y1, y2, width int) {
+	row1, row2 := s.row(y1, width), s.row(y2, width)
+	for i := 0; i*64 < width; i++ {
+		pos, n := s.offset+i*64, min(width-i*64, 64)
+		word1, word2 := readBits(row1, pos), readBits(row2, pos)
+		writeBits(row1, pos, n, word2)
+		writeBits(row2, pos, n, word1)
+	}
+}
+
+// copyRow copies the first width pixels of row y into dst, starting at the
+// top bit of dst[0], with the bits past the width cleared.
+func (s bitStore) copyRow(dst []uint64, y, width int) {
+	row := s.row(y, width)
+	for i := 0; i*64 < width; i++ {
+		dst[i] = readBits(row, s.offset+i*64)
+		if n := width - i*64; n < 64 {
+			dst[i] &= ^uint64(0) << (64 - n)
+		}
+	}
+}
+
+// storeRow is the inverse of copyRow.
+func (s bitStore) storeRow(y, width int, src []uint64) {
+	row := s.row(y, width)
+	for i := 0; i*64 < width; i++ {
+		writeBits(row, s.offset+i*64, min(width-i*64, 64), src[i])
+	}
+}
+
+// readBits returns the 64 bits of row starting at bit pos.
+func readBits(row []uint64, pos int) uint64 {
+	i, shift := pos/64, uint(pos%64)
+	word := row[i] << shift
+	if shift > 0 && i+1 < len(row) {
+		word |= row[i+1] >> (64 - shift)
+	}
+	return word
+}
+
+// writeBits stores the top n bits of value in row at bit pos, leaving the
+// bits around them alone.
+func writeBits(row []uint64, pos, n int, value uint64) {
+	mask := ^uint64(0) << (64 - n)
+	value &= mask
+	i, shift := pos/64, uint(pos%64)
+	row[i] = row[i]&^(mask>>shift) | value>>shift
+	if int(shift)+n > 64 {
+		row[i+1] = row[i+1]&^(mask<<(64-shift)) | value<<(64-shift)
+	}
+}
+
+func NewPBMFromImage(img image.Image) *PBM {
+	bounds := img.Bounds()
+	pbm := NewPBM(bounds.Dx(), bounds.Dy())
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			gray := color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray)
+			pbm.Set(x, y, gray.Y < 128)
+		}
+	}
+	return pbm
 }
 
 func ReadPBM(filename string) (*PBM, error) {
@@ -21,155 +166,247 @@ func ReadPBM(filename string) (*PBM, error) {
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	pbm := &PBM{}
-	line := 0
-
-	for scanner.Scan() {
-		text := scanner.Text()
+	return DecodePBM(file)
+}
 
-		if text == "" || strings.HasPrefix(text, "#") {
-			continue
-		}
+func DecodePBM(r io.Reader) (*PBM, error) {
+	return decodePBM(newTokenReader(r, "PBM"), nil)
+}
 
-		if pbm.magicNumber == "" {
-			pbm.magicNumber = strings.TrimSpace(text)
-		} else if pbm.width == 0 {
-			fmt.Sscanf(text, "%d %d", &pbm.width, &pbm.height)
-			pbm.data = make([][]bool, pbm.height)
-			for i := range pbm.data {
-				pbm.data[i] = make([]bool, pbm.width)
-			}
-		} else {
-			if pbm.magicNumber == "P1" {
-				test := strings.Fields(text)
-				for i := 0; i < pbm.width; i++ {
-					pbm.data[line][i] = (test[i] == "1")
-				}
-				line++
-			} else if pbm.magicNumber == "P4" {
-				expectedBytesPerRow := (pbm.width + 7) / 8
-				totalExpectedBytes := expectedBytesPerRow * pbm.height
-				allPixelData := make([]byte, totalExpectedBytes)
+func decodePBM(tr *tokenReader, opts *DecodeOptions) (*PBM, error) {
+	header, err := readHeader(tr)
+	if err != nil {
+		return nil, err
+	}
+	magicNumber, width, height := header.MagicNumber, header.Width, header.Height
+	if magicNumber != "P1" && magicNumber != "P4" {
+		return nil, tr.errorf(ErrBadMagic, "invalid PBM magic number %q", magicNumber)
+	}
+	rasterSize := int64(width) * int64(height)
+	if magicNumber == "P4" {
+		rasterSize = int64((width+7)/8) * int64(height)
+	}
+	if err := opts.checkLimits(tr, width, height, rasterSize); err != nil {
+		return nil, err
+	}
 
-				fileContent, err := os.ReadFile(filename)
+	// Plain pixels are packed as in P4, so that both flavors are stored
+	// from a raw raster.
+	var data []byte
+	if magicNumber == "P1" {
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				value, err := tr.bit()
 				if err != nil {
-					return nil, fmt.Errorf("couldn't read file: %v", err)
+					return nil, err
 				}
-
-				copy(allPixelData, fileContent[len(fileContent)-totalExpectedBytes:])
-
-				byteIndex := 0
-				for y := 0; y < pbm.height; y++ {
-					for x := 0; x < pbm.width; x++ {
-						if x%8 == 0 && x != 0 {
-							byteIndex++
-						}
-						pbm.data[y][x] = (allPixelData[byteIndex]>>(7-(x%8)))&1 != 0
-					}
-					byteIndex++
+				if x%8 == 0 {
+					data = append(data, 0)
+				}
+				if value {
+					data[len(data)-1] |= 0x80 >> (x % 8)
 				}
-				break
 			}
 		}
+	} else {
+		tr.startRaw()
+		if data, err = tr.raster((width + 7) / 8 * height); err != nil {
+			return nil, err
+		}
 	}
 
+	pbm := newPBM(width, height, bitStoreOf(data, width, height))
+	pbm.magicNumber = magicNumber
+	pbm.lines = header.Comments
 	return pbm, nil
 }
 
-func (pbm *PBM) Size() (int, int) {
-	return pbm.width, pbm.height
+func (pbm *PBM) ColorModel() color.Model {
+	return pbmPalette
+}
+
+func (pbm *PBM) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(pbm.Bounds())) {
+		return color.Gray{}
+	}
+	if pbm.BitAt(x, y) {
+		return color.Black
+	}
+	return color.White
+}
+
+// SubImage returns a view of the part of pbm inside r. The view shares
+// pbm's words, so Set, Invert, Flip and Flop on it change pbm as well.
+// Rotate90CW and KNearestNeighbors give the view words of its own.
+func (pbm *PBM) SubImage(r image.Rectangle) *PBM {
+	return pbm.with(pbm.Image.SubImage(r))
+}
+
+// Crop returns a copy of the part of pbm inside r, with its origin at (0, 0).
+func (pbm *PBM) Crop(r image.Rectangle) *PBM {
+	return pbm.with(pbm.Image.Crop(r))
 }
 
-func (pbm *PBM) At(x, y int) bool {
-	return pbm.data[y][x]
+func (pbm *PBM) Clone() PNM {
+	return pbm.with(pbm.Image.Clone())
 }
 
-func (pbm *PBM) Set(x, y int, value bool) {
-	pbm.data[y][x] = value
+// with returns a PBM holding img with pbm's magic number and comments.
+func (pbm *PBM) with(img *Image[bool]) *PBM {
+	return &PBM{
+		Image:       *img,
+		magicNumber: pbm.magicNumber,
+		comments:    comments{lines: pbm.Comments()},
+	}
+}
+
+func (pbm *PBM) bits() bitStore {
+	return pbm.store.(bitStore)
+}
+
+// Row returns the words holding row y, backed by the image's memory. The
+// row's first pixel is bit 63-Offset() of the first word.
+func (pbm *PBM) Row(y int) []uint64 {
+	return pbm.bits().row(y-pbm.rect.Min.Y, pbm.rect.Dx())
+}
+
+func (pbm *PBM) Stride() int {
+	return pbm.bits().stride
+}
+
+func (pbm *PBM) Offset() int {
+	return pbm.bits().offset
+}
+
+func (pbm *PBM) BitAt(x, y int) bool {
+	return pbm.Get(x, y)
 }
 
 func (pbm *PBM) Save(filename string) error {
-	file, err := os.Create(filename)
-	if err != nil {
+	return createAndEncode(filename, func(w io.Writer) error {
+		return EncodePBM(w, pbm)
+	})
+}
+
+func (pbm *PBM) Encode(w io.Writer) error {
+	return EncodePBM(w, pbm)
+}
+
+func EncodePBM(w io.Writer, pbm *PBM) error {
+	return encodePBM(w, pbm, nil)
+}
+
+func encodePBM(w io.Writer, pbm *PBM, opts *EncodeOptions) error {
+	if pbm.magicNumber != "P1" && pbm.magicNumber != "P4" {
+		return fmt.Errorf("invalid PBM magic number %q", pbm.magicNumber)
+	}
+	if err := opts.check(); err != nil {
 		return err
 	}
-	defer file.Close()
+	magicNumber := opts.magicNumber(pbm.magicNumber)
 
-	writer := bufio.NewWriter(file)
-	fmt.Fprint(writer, pbm.magicNumber+"\n")
-	fmt.Fprintf(writer, "%d %d\n", pbm.width, pbm.height)
-	writer.Flush()
-
-	if pbm.magicNumber == "P1" {
-		for y, row := range pbm.data {
-			for i, pixel := range row {
-				xtra := " "
-				if i == len(row)-1 {
-					xtra = ""
-				}
-				if pixel {
-					fmt.Fprint(writer, "1"+xtra)
+	writer := bufio.NewWriter(w)
+	opts.writeHeader(writer, magicNumber, pbm.lines)
+	width, height := pbm.Size()
+	fmt.Fprintf(writer, "%d %d\n", width, height)
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing header: %w", err)
+	}
+
+	if magicNumber == "P1" {
+		plain := opts.plainWriter(writer, "")
+		for y := pbm.rect.Min.Y; y < pbm.rect.Max.Y; y++ {
+			for x := pbm.rect.Min.X; x < pbm.rect.Max.X; x++ {
+				if pbm.BitAt(x, y) {
+					plain.write("1")
 				} else {
-					fmt.Fprint(writer, "0"+xtra)
+					plain.write("0")
 				}
 			}
-			if y != len(pbm.data)-1 {
-				fmt.Fprintln(writer, "")
-			}
+			plain.endRow()
 		}
-		writer.Flush()
-	} else if pbm.magicNumber == "P4" {
-		for _, row := range pbm.data {
-			for x := 0; x < pbm.width; x += 8 {
-				var byteValue byte
-				for i := 0; i < 8 && x+i < pbm.width; i++ {
-					bitIndex := 7 - i
-					if row[x+i] {
-						byteValue |= 1 << bitIndex
-					}
-				}
-				_, err = file.Write([]byte{byteValue})
-				if err != nil {
-					return fmt.Errorf("error writing pixel data: %v", err)
-				}
+	} else {
+		row := make([]uint64, (width+63)/64)
+		rowData := make([]byte, len(row)*8)
+		for y := 0; y < height; y++ {
+			pbm.bits().copyRow(row, y, width)
+			for i, word := range row {
+				binary.BigEndian.PutUint64(rowData[i*8:], word)
+			}
+			if _, err := writer.Write(rowData[:(width+7)/8]); err != nil {
+				return fmt.Errorf("error writing pixel data: %w", err)
 			}
 		}
 	}
 
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing pixel data: %w", err)
+	}
 	return nil
 }
 
 func (pbm *PBM) Invert() {
-	for y, row := range pbm.data {
-		for x := range row {
-			pbm.data[y][x] = !pbm.data[y][x]
+	s, width := pbm.bits(), pbm.rect.Dx()
+	for y := 0; y < pbm.rect.Dy(); y++ {
+		row := s.row(y, width)
+		for i := 0; i*64 < width; i++ {
+			pos := s.offset + i*64
+			writeBits(row, pos, min(width-i*64, 64), ^readBits(row, pos))
 		}
 	}
 }
 
+// Flip reverses the words of each row and the bits of each word, which
+// leaves the pixels shifted right by the padding; shifting the row back
+// left restores them.
 func (pbm *PBM) Flip() {
-	for y, row := range pbm.data {
-		cursor := pbm.width - 1
-		for x := 0; x < pbm.width/2; x++ {
-			temp := row[x]
-			pbm.data[y][x] = row[cursor]
-			pbm.data[y][cursor] = temp
+	s, width := pbm.bits(), pbm.rect.Dx()
+	row := make([]uint64, (width+63)/64)
+	padding := uint(len(row)*64 - width)
+	for y := 0; y < pbm.rect.Dy(); y++ {
+		s.copyRow(row, y, width)
+		cursor := len(row) - 1
+		for i := 0; i <= cursor; i++ {
+			row[i], row[cursor] = bits.Reverse64(row[cursor]), bits.Reverse64(row[i])
 			cursor--
 		}
+		if padding > 0 {
+			for i := range row {
+				row[i] <<= padding
+				if i+1 < len(row) {
+					row[i] |= row[i+1] >> (64 - padding)
+				}
+			}
+		}
+		s.storeRow(y, width, row)
 	}
 }
 
-func (pbm *PBM) Flop() {
-	cursor := pbm.height - 1
-	for y := 0; y < pbm.height/2; y++ {
-		temp := pbm.data[y]
-		pbm.data[y] = pbm.data[cursor]
-		pbm.data[cursor] = temp
-		cursor--
-	}
+func (pbm *PBM) MagicNumber() string {
+	return pbm.magicNumber
 }
 
 func (pbm *PBM) SetMagicNumber(magicNumber string) {
 	pbm.magicNumber = magicNumber
 }
+
+func (pbm *PBM) ToPGM() *PGM {
+	return (*ConvertOptions)(nil).ToPGM(pbm)
+}
+
+func (pbm *PBM) ToPPM() *PPM {
+	return (*ConvertOptions)(nil).ToPPM(pbm)
+}
+
+func (pbm *PBM) ToPAM() *PAM {
+	width, height := pbm.Size()
+	pam := newPAM(width, height, 1, TupleTypeBlackAndWhite, 1)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if !pbm.BitAt(pbm.rect.Min.X+x, pbm.rect.Min.Y+y) {
+				pam.data[y][x] = 1
+			}
+		}
+	}
+	return pam
+}
@@ -0,0 +1,118 @@
+package Netpbm
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// TestPBMViewReference checks SubImage views of bitStore images against a
+// [][]bool reference, pixel by pixel, around the word boundaries. Every
+// operation on the view is checked on the whole parent, so that bits
+// written past the view's edges show up.
+func TestPBMViewReference(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	widths := []int{1, 7, 62, 63, 64, 65, 66, 127, 128, 129, 191, 192, 193}
+	for i := 0; i < 10; i++ {
+		widths = append(widths, 60+rng.Intn(140))
+	}
+
+	for _, width := range widths {
+		height := 1 + rng.Intn(5)
+		parent := NewPBM(width, height)
+		reference := make([][]bool, height)
+		for y := range reference {
+			reference[y] = make([]bool, width)
+			for x := range reference[y] {
+				reference[y][x] = rng.Intn(2) == 0
+				parent.Set(x, y, reference[y][x])
+			}
+		}
+
+		for i := 0; i < 20; i++ {
+			x0, y0 := rng.Intn(width), rng.Intn(height)
+			r := image.Rect(x0, y0, x0+1+rng.Intn(width-x0), y0+1+rng.Intn(height-y0))
+			view := parent.SubImage(r)
+
+			check := func(op string) {
+				t.Helper()
+				for y := range reference {
+					for x, want := range reference[y] {
+						if got := parent.Get(x, y); got != want {
+							t.Fatalf("width %d, view %v, after %s: parent pixel (%d, %d) is %v, want %v", width, r, op, x, y, got, want)
+						}
+						if image.Pt(x, y).In(r) && view.Get(x, y) != want {
+							t.Fatalf("width %d, view %v, after %s: view pixel (%d, %d) is %v, want %v", width, r, op, x, y, !want, want)
+						}
+					}
+				}
+			}
+
+			view.Invert()
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					reference[y][x] = !reference[y][x]
+				}
+			}
+			check("Invert")
+
+			view.Flip()
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				row := reference[y][r.Min.X:r.Max.X]
+				for a, b := 0, len(row)-1; a < b; a, b = a+1, b-1 {
+					row[a], row[b] = row[b], row[a]
+				}
+			}
+			check("Flip")
+
+			view.Flop()
+			for a, b := r.Min.Y, r.Max.Y-1; a < b; a, b = a+1, b-1 {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					reference[a][x], reference[b][x] = reference[b][x], reference[a][x]
+				}
+			}
+			check("Flop")
+
+			x, y := r.Min.X+rng.Intn(r.Dx()), r.Min.Y+rng.Intn(r.Dy())
+			reference[y][x] = !reference[y][x]
+			view.Set(x, y, reference[y][x])
+			check("Set")
+
+			var buf bytes.Buffer
+			view.SetMagicNumber("P4")
+			if err := view.Encode(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if want := len(fmt.Sprintf("P4\n%d %d\n", r.Dx(), r.Dy())) + (r.Dx()+7)/8*r.Dy(); buf.Len() != want {
+				t.Fatalf("width %d, view %v: encoded %d bytes, want %d", width, r, buf.Len(), want)
+			}
+			decoded, err := DecodePBM(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					if got := decoded.Get(x-r.Min.X, y-r.Min.Y); got != reference[y][x] {
+						t.Fatalf("width %d, view %v: encoded pixel (%d, %d) is %v, want %v", width, r, x, y, got, reference[y][x])
+					}
+				}
+			}
+			check("Encode")
+		}
+	}
+}
+
+func BenchmarkEncodePBM(b *testing.B) {
+	pbm := gradientPPM(2000, 1500, 255).ToPBM()
+	pbm.SetMagicNumber("P4")
+	b.SetBytes(250 * 1500)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := EncodePBM(io.Discard, pbm); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
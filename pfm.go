@@ -0,0 +1,278 @@
+package Netpbm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// PFM is a Portable FloatMap. Rows are kept top to bottom in memory even
+// though the file stores them bottom to top.
+type PFM struct {
+	data         [][]float32
+	width        int
+	height       int
+	channels     int
+	scale        float32
+	littleEndian bool
+}
+
+// ToneMapping controls how float samples are mapped to integer samples.
+// Samples are multiplied by 2^Exposure; with Clamp they are then clipped
+// to [0, 1]. Without Clamp, an image whose brightest sample would exceed 1
+// is scaled down so that it maps to 1 instead; darker images are left as
+// they are.
+type ToneMapping struct {
+	Exposure float64
+	Clamp    bool
+	MaxValue uint16
+}
+
+// NewPFM returns a PFM with 1 (Pf) or 3 (PF) channels.
+func NewPFM(width, height, channels int) (*PFM, error) {
+	if channels != 1 && channels != 3 {
+		return nil, fmt.Errorf("invalid PFM channel count %d", channels)
+	}
+	return newPFM(width, height, channels), nil
+}
+
+func newPFM(width, height, channels int) *PFM {
+	pfm := &PFM{
+		data:         make([][]float32, height),
+		width:        width,
+		height:       height,
+		channels:     channels,
+		scale:        1,
+		littleEndian: true,
+	}
+	for y := range pfm.data {
+		pfm.data[y] = make([]float32, width*channels)
+	}
+	return pfm
+}
+
+func ReadPFM(filename string) (*PFM, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return DecodePFM(file)
+}
+
+func DecodePFM(r io.Reader) (*PFM, error) {
+	return decodePFM(newTokenReader(r, "PFM"), nil)
+}
+
+func decodePFM(tr *tokenReader, opts *DecodeOptions) (*PFM, error) {
+	magicNumber, err := tr.magic()
+	if err != nil {
+		return nil, err
+	}
+	channels := 0
+	switch magicNumber {
+	case "PF":
+		channels = 3
+	case "Pf":
+		channels = 1
+	default:
+		return nil, tr.errorf(ErrBadMagic, "invalid PFM magic number %q", magicNumber)
+	}
+
+	width, height, err := tr.dimensions()
+	if err != nil {
+		return nil, err
+	}
+	token, err := tr.token("scale")
+	if err != nil {
+		return nil, err
+	}
+	scale, err := strconv.ParseFloat(token, 32)
+	if err != nil || scale == 0 || math.IsNaN(scale) || math.IsInf(scale, 0) {
+		return nil, tr.errorf(ErrSyntax, "invalid scale %q", token)
+	}
+	if err := tr.checkRasterSize(width, height, width, channels, 4); err != nil {
+		return nil, err
+	}
+	if err := opts.checkLimits(tr, width, height, int64(width)*int64(height)*int64(channels)*4); err != nil {
+		return nil, err
+	}
+
+	tr.startRaw()
+	data, err := tr.raster(width * height * channels * 4)
+	if err != nil {
+		return nil, err
+	}
+
+	pfm := &PFM{
+		data:         make([][]float32, height),
+		width:        width,
+		height:       height,
+		channels:     channels,
+		scale:        float32(math.Abs(scale)),
+		littleEndian: scale < 0,
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if pfm.littleEndian {
+		order = binary.LittleEndian
+	}
+	rowSize := width * channels * 4
+	for y := range pfm.data {
+		rowData := data[(height-1-y)*rowSize:]
+		pfm.data[y] = make([]float32, width*channels)
+		for i := range pfm.data[y] {
+			pfm.data[y][i] = math.Float32frombits(order.Uint32(rowData[i*4:]))
+		}
+	}
+
+	return pfm, nil
+}
+
+func (pfm *PFM) Save(filename string) error {
+	return createAndEncode(filename, func(w io.Writer) error {
+		return EncodePFM(w, pfm)
+	})
+}
+
+func EncodePFM(w io.Writer, pfm *PFM) error {
+	magicNumber := "Pf"
+	if pfm.channels == 3 {
+		magicNumber = "PF"
+	}
+	scale := pfm.scale
+	var order binary.ByteOrder = binary.BigEndian
+	if pfm.littleEndian {
+		scale = -scale
+		order = binary.LittleEndian
+	}
+
+	writer := bufio.NewWriter(w)
+	fmt.Fprint(writer, magicNumber+"\n")
+	fmt.Fprintf(writer, "%d %d\n", pfm.width, pfm.height)
+	fmt.Fprintf(writer, "%s\n", strconv.FormatFloat(float64(scale), 'f', -1, 32))
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing header: %w", err)
+	}
+
+	rowData := make([]byte, pfm.width*pfm.channels*4)
+	for y := pfm.height - 1; y >= 0; y-- {
+		for i, value := range pfm.data[y] {
+			order.PutUint32(rowData[i*4:], math.Float32bits(value))
+		}
+		if _, err := writer.Write(rowData); err != nil {
+			return fmt.Errorf("error writing pixel data: %w", err)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing pixel data: %w", err)
+	}
+	return nil
+}
+
+func (pfm *PFM) Size() (int, int) {
+	return pfm.width, pfm.height
+}
+
+func (pfm *PFM) Channels() int {
+	return pfm.channels
+}
+
+func (pfm *PFM) Scale() float32 {
+	return pfm.scale
+}
+
+// SetScale sets the scale factor written to the header. Its sign is
+// ignored: the byte order is set with SetLittleEndian.
+func (pfm *PFM) SetScale(scale float32) error {
+	if v := float64(scale); v == 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Errorf("invalid PFM scale %v", scale)
+	}
+	pfm.scale = float32(math.Abs(float64(scale)))
+	return nil
+}
+
+func (pfm *PFM) LittleEndian() bool {
+	return pfm.littleEndian
+}
+
+func (pfm *PFM) SetLittleEndian(littleEndian bool) {
+	pfm.littleEndian = littleEndian
+}
+
+func (pfm *PFM) FloatAt(x, y int) []float32 {
+	return pfm.data[y][x*pfm.channels : (x+1)*pfm.channels]
+}
+
+func (pfm *PFM) SetFloat(x, y int, values ...float32) {
+	copy(pfm.data[y][x*pfm.channels:(x+1)*pfm.channels], values)
+}
+
+// toneMapper returns a function mapping a float sample to [0, maxValue].
+func (pfm *PFM) toneMapper(tm ToneMapping) (func(float32) uint16, uint16) {
+	maxValue := tm.MaxValue
+	if maxValue == 0 {
+		maxValue = 255
+	}
+	gain := math.Exp2(tm.Exposure)
+
+	if !tm.Clamp {
+		brightest := 0.0
+		for _, row := range pfm.data {
+			for _, value := range row {
+				if v := float64(value); v > brightest && !math.IsInf(v, 1) {
+					brightest = v
+				}
+			}
+		}
+		if brightest*gain > 1 {
+			gain = 1 / brightest
+		}
+	}
+
+	return func(value float32) uint16 {
+		v := float64(value) * gain
+		if math.IsNaN(v) || v < 0 {
+			v = 0
+		} else if v > 1 {
+			v = 1
+		}
+		return uint16(math.Round(v * float64(maxValue)))
+	}, maxValue
+}
+
+func (pfm *PFM) ToPGM(tm ToneMapping) *PGM {
+	toneMap, maxValue := pfm.toneMapper(tm)
+	pgm := NewPGM(pfm.width, pfm.height, maxValue)
+	for y, row := range pfm.data {
+		for x := 0; x < pfm.width; x++ {
+			var sum float32
+			for _, value := range row[x*pfm.channels : (x+1)*pfm.channels] {
+				sum += value
+			}
+			pgm.Set(x, y, toneMap(sum/float32(pfm.channels)))
+		}
+	}
+	return pgm
+}
+
+func (pfm *PFM) ToPPM(tm ToneMapping) *PPM {
+	toneMap, maxValue := pfm.toneMapper(tm)
+	ppm := NewPPM(pfm.width, pfm.height, maxValue)
+	for y, row := range pfm.data {
+		for x := 0; x < pfm.width; x++ {
+			if pfm.channels == 3 {
+				ppm.Set(x, y, Pixel{R: toneMap(row[x*3]), G: toneMap(row[x*3+1]), B: toneMap(row[x*3+2])})
+			} else {
+				value := toneMap(row[x])
+				ppm.Set(x, y, Pixel{R: value, G: value, B: value})
+			}
+		}
+	}
+	return ppm
+}
@@ -0,0 +1,74 @@
+package Netpbm
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestPFMSetScale(t *testing.T) {
+	for _, scale := range []float32{0, float32(math.NaN()), float32(math.Inf(1)), float32(math.Inf(-1))} {
+		if err := newPFM(1, 1, 1).SetScale(scale); err == nil {
+			t.Errorf("scale %v accepted", scale)
+		}
+	}
+
+	pfm := newPFM(1, 1, 1)
+	pfm.SetFloat(0, 0, 0.157)
+	if err := pfm.SetScale(-2); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := EncodePFM(&buf, pfm); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodePFM(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Scale() != 2 || !decoded.LittleEndian() || decoded.FloatAt(0, 0)[0] != 0.157 {
+		t.Errorf("got scale %v, little endian %v, sample %v", decoded.Scale(), decoded.LittleEndian(), decoded.FloatAt(0, 0)[0])
+	}
+}
+
+func TestNewPFM(t *testing.T) {
+	for _, channels := range []int{-1, 0, 2, 4} {
+		if _, err := NewPFM(1, 1, channels); err == nil {
+			t.Errorf("%d channels accepted", channels)
+		}
+	}
+	for _, channels := range []int{1, 3} {
+		pfm, err := NewPFM(2, 2, channels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := EncodePFM(&buf, pfm); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodePFM(&buf)
+		if err != nil {
+			t.Fatalf("can't read back %d channels: %v", channels, err)
+		}
+		if decoded.Channels() != channels {
+			t.Errorf("got %d channels, want %d", decoded.Channels(), channels)
+		}
+	}
+}
+
+func TestPFMToneMapping(t *testing.T) {
+	pfm := newPFM(2, 1, 1)
+	pfm.SetFloat(0, 0, 0.25)
+	pfm.SetFloat(1, 0, 0.5)
+	// Nothing exceeds 1, so the samples keep their level.
+	if pgm := pfm.ToPGM(ToneMapping{}); pgm.GrayAt(0, 0) != 64 || pgm.GrayAt(1, 0) != 128 {
+		t.Errorf("dark image mapped to %d, %d", pgm.GrayAt(0, 0), pgm.GrayAt(1, 0))
+	}
+	// Two stops brighter, the brightest sample is scaled down to 1.
+	if pgm := pfm.ToPGM(ToneMapping{Exposure: 2}); pgm.GrayAt(0, 0) != 128 || pgm.GrayAt(1, 0) != 255 {
+		t.Errorf("bright image mapped to %d, %d", pgm.GrayAt(0, 0), pgm.GrayAt(1, 0))
+	}
+	if pgm := pfm.ToPGM(ToneMapping{Exposure: 2, Clamp: true}); pgm.GrayAt(0, 0) != 255 || pgm.GrayAt(1, 0) != 255 {
+		t.Errorf("clamped image mapped to %d, %d", pgm.GrayAt(0, 0), pgm.GrayAt(1, 0))
+	}
+}
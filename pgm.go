@@ -3,17 +3,73 @@ package Netpbm
 import (
 	"bufio"
 	"fmt"
+	"image"
+	"image/color"
+	"io"
 	"os"
 	"strconv"
-	"strings"
 )
 
+// PGM is an Image[uint16] whose samples are kept as in a raw P5 raster: one
+// byte when max is at most 255 and two bytes, most significant first,
+// otherwise.
 type PGM struct {
-	data        [][]uint8
-	width       int
-	height      int
+	Image[uint16]
 	magicNumber string
-	max         uint8
+	max         uint16
+	comments
+}
+
+func NewPGM(width, height int, maxValue uint16) *PGM {
+	return newPGM(width, height, maxValue, newSamples(width, height, 1, bytesPerSample(maxValue)))
+}
+
+func newPGM(width, height int, maxValue uint16, s samples) *PGM {
+	return &PGM{
+		Image: Image[uint16]{
+			store: grayStore{s},
+			rect:  image.Rect(0, 0, width, height),
+		},
+		magicNumber: "P5",
+		max:         maxValue,
+	}
+}
+
+// grayStore keeps one sample per pixel.
+type grayStore struct {
+	samples
+}
+
+func (s grayStore) at(x, y int) uint16 {
+	return getSample(s.pix[y*s.stride:], x, s.size)
+}
+
+func (s grayStore) set(x, y int, value uint16) {
+	putSample(s.pix[y*s.stride:], x, s.size, value)
+}
+
+func (s grayStore) sub(x, y int) store[uint16] {
+	return grayStore{s.subSamples(x, y)}
+}
+
+func (s grayStore) make(width, height int) store[uint16] {
+	return grayStore{newSamples(width, height, 1, s.size)}
+}
+
+func NewPGMFromImage(img image.Image) *PGM {
+	bounds := img.Bounds()
+	var maxValue uint16 = 255
+	if is16BitModel(img.ColorModel()) {
+		maxValue = 65535
+	}
+	pgm := NewPGM(bounds.Dx(), bounds.Dy(), maxValue)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			gray := color.Gray16Model.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray16)
+			pgm.Set(x, y, scaleSample(gray.Y, 65535, maxValue))
+		}
+	}
+	return pgm
 }
 
 func ReadPGM(filename string) (*PGM, error) {
@@ -23,186 +79,225 @@ func ReadPGM(filename string) (*PGM, error) {
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	pgm := &PGM{}
-	line := 0
+	return DecodePGM(file)
+}
 
-	for scanner.Scan() {
-		text := scanner.Text()
+func DecodePGM(r io.Reader) (*PGM, error) {
+	return decodePGM(newTokenReader(r, "PGM"), nil)
+}
 
-		if strings.HasPrefix(text, "#") {
-			continue
-		}
+func decodePGM(tr *tokenReader, opts *DecodeOptions) (*PGM, error) {
+	header, err := readHeader(tr)
+	if err != nil {
+		return nil, err
+	}
+	magicNumber, width, height, maxValue := header.MagicNumber, header.Width, header.Height, header.MaxValue
+	if magicNumber != "P2" && magicNumber != "P5" {
+		return nil, tr.errorf(ErrBadMagic, "invalid PGM magic number %q", magicNumber)
+	}
+	rasterSize := int64(width) * int64(height)
+	if magicNumber == "P5" {
+		rasterSize *= int64(bytesPerSample(maxValue))
+	}
+	if err := opts.checkLimits(tr, width, height, rasterSize); err != nil {
+		return nil, err
+	}
 
-		if pgm.magicNumber == "" {
-			pgm.magicNumber = strings.TrimSpace(text)
-		} else if pgm.width == 0 {
-			fmt.Sscanf(text, "%d %d", &pgm.width, &pgm.height)
-			pgm.data = make([][]uint8, pgm.height)
-			for i := range pgm.data {
-				pgm.data[i] = make([]uint8, pgm.width)
-			}
-		} else if pgm.max == 0 {
-			fmt.Sscanf(text, "%d", &pgm.max)
-		} else {
-			if pgm.magicNumber == "P2" {
-				val := strings.Fields(text)
-				for i := 0; i < pgm.width; i++ {
-					num, _ := strconv.ParseUint(val[i], 10, 8)
-					pgm.data[line][i] = uint8(num)
-				}
-				line++
-			} else if pgm.magicNumber == "P5" {
-				pixelData := make([]uint8, pgm.width*pgm.height)
-				fileContent, err := os.ReadFile(filename)
-				if err != nil {
-					return nil, fmt.Errorf("couldn't read file: %v", err)
-				}
-				copy(pixelData, fileContent[len(fileContent)-(pgm.width*pgm.height):])
-				pixelIndex := 0
-				for y := 0; y < pgm.height; y++ {
-					for x := 0; x < pgm.width; x++ {
-						pgm.data[y][x] = pixelData[pixelIndex]
-						pixelIndex++
-					}
-				}
-				break
+	size := bytesPerSample(maxValue)
+	var pix []uint8
+	if magicNumber == "P2" {
+		for i := 0; i < width*height; i++ {
+			value, err := tr.sample(maxValue)
+			if err != nil {
+				return nil, err
 			}
+			pix = appendSample(pix, size, value)
+		}
+	} else {
+		tr.startRaw()
+		if pix, err = tr.raster(width * height * size); err != nil {
+			return nil, err
+		}
+		if err := tr.checkSamples(pix, maxValue); err != nil {
+			return nil, err
 		}
 	}
 
+	pgm := newPGM(width, height, maxValue, samplesOf(pix, width, 1, size))
+	pgm.magicNumber = magicNumber
+	pgm.lines = header.Comments
 	return pgm, nil
 }
 
-func (pgm *PGM) Size() (int, int) {
-	return pgm.width, pgm.height
+func (pgm *PGM) ColorModel() color.Model {
+	if pgm.max > 255 {
+		return color.Gray16Model
+	}
+	return color.GrayModel
+}
+
+func (pgm *PGM) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(pgm.Bounds())) {
+		return color.Gray{}
+	}
+	if pgm.max > 255 {
+		return color.Gray16{Y: scaleSample(pgm.GrayAt(x, y), pgm.max, 65535)}
+	}
+	return color.Gray{Y: uint8(scaleSample(pgm.GrayAt(x, y), pgm.max, 255))}
+}
+
+// SubImage returns a view of the part of pgm inside r. The view shares
+// pgm's samples, so Set, Invert, Flip and Flop on it change pgm as well.
+// SetMaxValue, Rotate90CW and KNearestNeighbors give the view samples of
+// its own.
+func (pgm *PGM) SubImage(r image.Rectangle) *PGM {
+	return pgm.with(pgm.Image.SubImage(r))
 }
 
-func (pgm *PGM) At(x, y int) uint8 {
-	return pgm.data[y][x]
+// Crop returns a copy of the part of pgm inside r, with its origin at (0, 0).
+func (pgm *PGM) Crop(r image.Rectangle) *PGM {
+	return pgm.with(pgm.Image.Crop(r))
 }
 
-func (pgm *PGM) Set(x, y int, value uint8) {
-	pgm.data[y][x] = value
+func (pgm *PGM) Clone() PNM {
+	return pgm.with(pgm.Image.Clone())
+}
+
+// with returns a PGM holding img with pgm's magic number, max value and
+// comments.
+func (pgm *PGM) with(img *Image[uint16]) *PGM {
+	return &PGM{
+		Image:       *img,
+		magicNumber: pgm.magicNumber,
+		max:         pgm.max,
+		comments:    comments{lines: pgm.Comments()},
+	}
+}
+
+// Row returns the encoded samples of row y, backed by the image's memory.
+func (pgm *PGM) Row(y int) []uint8 {
+	return pgm.store.(grayStore).row(y-pgm.rect.Min.Y, pgm.rect.Dx())
+}
+
+func (pgm *PGM) Stride() int {
+	return pgm.store.(grayStore).stride
+}
+
+func (pgm *PGM) GrayAt(x, y int) uint16 {
+	return pgm.Get(x, y)
 }
 
 func (pgm *PGM) Save(filename string) error {
-	file, err := os.Create(filename)
-	if err != nil {
+	return createAndEncode(filename, func(w io.Writer) error {
+		return EncodePGM(w, pgm)
+	})
+}
+
+func (pgm *PGM) Encode(w io.Writer) error {
+	return EncodePGM(w, pgm)
+}
+
+func EncodePGM(w io.Writer, pgm *PGM) error {
+	return encodePGM(w, pgm, nil)
+}
+
+func encodePGM(w io.Writer, pgm *PGM, opts *EncodeOptions) error {
+	if pgm.magicNumber != "P2" && pgm.magicNumber != "P5" {
+		return fmt.Errorf("invalid PGM magic number %q", pgm.magicNumber)
+	}
+	if pgm.max == 0 {
+		return fmt.Errorf("invalid PGM max value %d", pgm.max)
+	}
+	if err := opts.check(); err != nil {
 		return err
 	}
-	defer file.Close()
+	magicNumber := opts.magicNumber(pgm.magicNumber)
 
-	writer := bufio.NewWriter(file)
-	fmt.Fprint(writer, pgm.magicNumber+"\n")
-	fmt.Fprintf(writer, "%d %d\n", pgm.width, pgm.height)
+	writer := bufio.NewWriter(w)
+	opts.writeHeader(writer, magicNumber, pgm.lines)
+	width, height := pgm.Size()
+	fmt.Fprintf(writer, "%d %d\n", width, height)
 	fmt.Fprintf(writer, "%d\n", pgm.max)
-	writer.Flush()
-
-	if pgm.magicNumber == "P2" {
-		for y, row := range pgm.data {
-			for i, pixel := range row {
-				xtra := " "
-				if i == len(row)-1 {
-					xtra = ""
-				}
-				fmt.Fprint(writer, strconv.Itoa(int(pixel))+xtra)
-			}
-			if y != len(pgm.data)-1 {
-				fmt.Fprintln(writer, "")
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing header: %w", err)
+	}
+
+	if magicNumber == "P2" {
+		plain := opts.plainWriter(writer, " ")
+		for y := pgm.rect.Min.Y; y < pgm.rect.Max.Y; y++ {
+			for x := pgm.rect.Min.X; x < pgm.rect.Max.X; x++ {
+				plain.write(strconv.Itoa(int(pgm.GrayAt(x, y))))
 			}
+			plain.endRow()
 		}
-		writer.Flush()
-	} else if pgm.magicNumber == "P5" {
-		for _, row := range pgm.data {
-			for _, pixel := range row {
-				_, err = file.Write([]byte{pixel})
-				if err != nil {
-					return fmt.Errorf("error writing pixel data: %v", err)
-				}
+	} else {
+		for y := pgm.rect.Min.Y; y < pgm.rect.Max.Y; y++ {
+			if _, err := writer.Write(pgm.Row(y)); err != nil {
+				return fmt.Errorf("error writing pixel data: %w", err)
 			}
 		}
 	}
 
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing pixel data: %w", err)
+	}
 	return nil
 }
 
 func (pgm *PGM) Invert() {
-	for y, row := range pgm.data {
-		for x := range row {
-			pgm.data[y][x] = pgm.max - pgm.data[y][x]
-		}
-	}
+	pgm.Map(func(value uint16) uint16 {
+		return invertSample(value, pgm.max)
+	})
 }
 
-func (pgm *PGM) Flip() {
-	for y, row := range pgm.data {
-		cursor := pgm.width - 1
-		for x := 0; x < pgm.width/2; x++ {
-			temp := row[x]
-			pgm.data[y][x] = row[cursor]
-			pgm.data[y][cursor] = temp
-			cursor--
-		}
-	}
-}
-
-func (pgm *PGM) Flop() {
-	cursor := pgm.height - 1
-	for y := 0; y < pgm.height/2; y++ {
-		temp := pgm.data[y]
-		pgm.data[y] = pgm.data[cursor]
-		pgm.data[cursor] = temp
-		cursor--
-	}
+func (pgm *PGM) MagicNumber() string {
+	return pgm.magicNumber
 }
 
 func (pgm *PGM) SetMagicNumber(magicNumber string) {
 	pgm.magicNumber = magicNumber
 }
 
-func (pgm *PGM) SetMaxValue(maxValue uint8) {
-	for y, _ := range pgm.data {
-		for x, _ := range pgm.data[y] {
-			prevvalue := pgm.data[y][x]
-			newvalue := prevvalue * uint8(5) / pgm.max
-			pgm.data[y][x] = newvalue
-		}
+// SetMaxValue rescales the samples to maxValue, which must be at least 1.
+func (pgm *PGM) SetMaxValue(maxValue uint16) error {
+	if maxValue == 0 {
+		return fmt.Errorf("invalid PGM max value %d", maxValue)
 	}
-	pgm.max = maxValue
+	width, height := pgm.Size()
+	scaled := grayStore{newSamples(width, height, 1, bytesPerSample(maxValue))}
+	pgm.Each(func(x, y int, value uint16) {
+		scaled.set(x-pgm.rect.Min.X, y-pgm.rect.Min.Y, scaleSample(value, pgm.max, maxValue))
+	})
+	pgm.store, pgm.max = scaled, maxValue
+	return nil
 }
 
-func (pgm *PGM) Rotate90CW() {
-	rotatedData := make([][]uint8, pgm.width)
-	for i := range rotatedData {
-		rotatedData[i] = make([]uint8, pgm.height)
-	}
+func (pgm *PGM) ToPBM() *PBM {
+	return (*ConvertOptions)(nil).ToPBM(pgm)
+}
 
-	for i := 0; i < pgm.width; i++ {
-		for j := 0; j < pgm.height; j++ {
-			rotatedData[i][j] = pgm.data[pgm.height-1-j][i]
+func (pgm *PGM) ToPPM() *PPM {
+	return (*ConvertOptions)(nil).ToPPM(pgm)
+}
+
+func (pgm *PGM) ToPAM() *PAM {
+	width, height := pgm.Size()
+	pam := newPAM(width, height, 1, TupleTypeGrayscale, pgm.max)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pam.data[y][x] = pgm.GrayAt(pgm.rect.Min.X+x, pgm.rect.Min.Y+y)
 		}
 	}
-
-	pgm.width, pgm.height = pgm.height, pgm.width
-	pgm.data = rotatedData
+	return pam
 }
 
-func (pgm *PGM) ToPBM() *PBM {
-	pbm := &PBM{
-		magicNumber: "P1",
-		height:      pgm.height,
-		width:       pgm.width,
-		data:        make([][]bool, pgm.height),
-	}
-
-	for y, row := range pgm.data {
-		pbm.data[y] = make([]bool, pgm.width)
-		for x, grayValue := range row {
-			isBlack := grayValue < pgm.max/2
-			pbm.data[y][x] = isBlack
+func (pgm *PGM) ToPFM() *PFM {
+	width, height := pgm.Size()
+	pfm := newPFM(width, height, 1)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pfm.data[y][x] = float32(pgm.GrayAt(pgm.rect.Min.X+x, pgm.rect.Min.Y+y)) / float32(pgm.max)
 		}
 	}
-
-	return pbm
+	return pfm
 }
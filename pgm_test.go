@@ -0,0 +1,143 @@
+package Netpbm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+var testMaxValues = []uint16{1, 2, 3, 7, 15, 100, 255, 256, 1000, 4095, 65534, 65535}
+
+// rampPGM returns a one-row PGM whose values are spread evenly from 0 to
+// maxValue.
+func rampPGM(maxValue uint16) *PGM {
+	width := min(int(maxValue)+1, 1024)
+	pgm := NewPGM(width, 1, maxValue)
+	for x := 0; x < width; x++ {
+		pgm.Set(x, 0, uint16(x*int(maxValue)/(width-1)))
+	}
+	return pgm
+}
+
+// isNearest reports whether got, out of to, is the value nearest to
+// value, out of from.
+func isNearest(value, from, got, to uint16) bool {
+	diff := int64(got)*int64(from) - int64(value)*int64(to)
+	if diff < 0 {
+		diff = -diff
+	}
+	return 2*diff <= int64(from)
+}
+
+func TestPGMSetMaxValue(t *testing.T) {
+	for _, from := range testMaxValues {
+		for _, to := range testMaxValues {
+			t.Run(fmt.Sprintf("%d-%d", from, to), func(t *testing.T) {
+				original := rampPGM(from)
+				pgm := original.Clone().(*PGM)
+				if err := pgm.SetMaxValue(to); err != nil {
+					t.Fatal(err)
+				}
+				original.Each(func(x, y int, value uint16) {
+					if got := pgm.GrayAt(x, y); !isNearest(value, from, got, to) {
+						t.Fatalf("%d became %d", value, got)
+					}
+				})
+
+				if to < from {
+					return
+				}
+				if err := pgm.SetMaxValue(from); err != nil {
+					t.Fatal(err)
+				}
+				original.Each(func(x, y int, value uint16) {
+					if got := pgm.GrayAt(x, y); got != value {
+						t.Fatalf("%d came back as %d", value, got)
+					}
+				})
+			})
+		}
+	}
+}
+
+func TestPGMMaxValueZero(t *testing.T) {
+	pgm := rampPGM(255)
+	if err := pgm.SetMaxValue(0); err == nil {
+		t.Fatal("SetMaxValue(0) succeeded")
+	}
+	if pgm.max != 255 {
+		t.Fatalf("max value changed to %d", pgm.max)
+	}
+	if err := NewPGM(2, 2, 0).Encode(io.Discard); err == nil {
+		t.Fatal("encoded an image with max value 0")
+	}
+}
+
+func TestPGMEncodeRoundTrip(t *testing.T) {
+	for _, maxValue := range testMaxValues {
+		for _, magicNumber := range []string{"P2", "P5"} {
+			t.Run(fmt.Sprintf("%s-%d", magicNumber, maxValue), func(t *testing.T) {
+				pgm := rampPGM(maxValue)
+				pgm.SetMagicNumber(magicNumber)
+				var buf bytes.Buffer
+				if err := pgm.Encode(&buf); err != nil {
+					t.Fatal(err)
+				}
+				decoded, err := DecodePGM(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if decoded.MagicNumber() != magicNumber || decoded.max != maxValue {
+					t.Fatalf("got %s with max value %d", decoded.MagicNumber(), decoded.max)
+				}
+				pgm.Each(func(x, y int, value uint16) {
+					if got := decoded.GrayAt(x, y); got != value {
+						t.Fatalf("pixel %d: got %d, want %d", x, got, value)
+					}
+				})
+			})
+		}
+	}
+}
+
+func TestPGMInvert(t *testing.T) {
+	for _, maxValue := range testMaxValues {
+		t.Run(fmt.Sprint(maxValue), func(t *testing.T) {
+			original := rampPGM(maxValue)
+			pgm := original.Clone().(*PGM)
+			pgm.Invert()
+			original.Each(func(x, y int, value uint16) {
+				if got := pgm.GrayAt(x, y); got != maxValue-value {
+					t.Fatalf("%d inverted to %d", value, got)
+				}
+			})
+		})
+	}
+}
+
+func TestPGMToPBM(t *testing.T) {
+	for _, maxValue := range testMaxValues {
+		t.Run(fmt.Sprint(maxValue), func(t *testing.T) {
+			pgm := rampPGM(maxValue)
+			pbm := pgm.ToPBM()
+			pgm.Each(func(x, y int, value uint16) {
+				if want := 2*uint32(value) < uint32(maxValue); pbm.BitAt(x, y) != want {
+					t.Fatalf("%d: got black %v, want %v", value, pbm.BitAt(x, y), want)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkEncodePGM16(b *testing.B) {
+	pgm := gradientPPM(2000, 1500, 65535).ToPGM()
+	pgm.SetMagicNumber("P5")
+	b.SetBytes(2000 * 1500 * 2)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := EncodePGM(io.Discard, pgm); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
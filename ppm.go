@@ -3,501 +3,342 @@ package Netpbm
 import (
 	"bufio"
 	"fmt"
-	"math"
+	"image"
+	"image/color"
+	"io"
 	"os"
-	"sort"
 	"strconv"
-	"strings"
 )
 
+// PPM is an Image[Pixel] whose samples are kept as in a raw P6 raster, laid
+// out like PGM samples with three per pixel.
 type PPM struct {
-	data        [][]Pixel
-	width       int
-	height      int
+	Image[Pixel]
 	magicNumber string
-	max         uint8
+	max         uint16
+	comments
 }
 
 type Pixel struct {
-	R, G, B uint8
+	R, G, B uint16
 }
 
-func ReadPPM(filename string) (*PPM, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	ppm := &PPM{}
-	line := 0
-
-	for scanner.Scan() {
-		text := scanner.Text()
-
-		if strings.HasPrefix(text, "#") {
-			continue
-		}
+func NewPPM(width, height int, maxValue uint16) *PPM {
+	return newPPM(width, height, maxValue, newSamples(width, height, 3, bytesPerSample(maxValue)))
+}
 
-		if ppm.magicNumber == "" {
-			ppm.magicNumber = strings.TrimSpace(text)
-		} else if ppm.width == 0 {
-			fmt.Sscanf(text, "%d %d", &ppm.width, &ppm.height)
-			ppm.data = make([][]Pixel, ppm.height)
-			for i := range ppm.data {
-				ppm.data[i] = make([]Pixel, ppm.width)
-			}
-		} else if ppm.max == 0 {
-			fmt.Sscanf(text, "%d", &ppm.max)
-		} else {
-			if ppm.magicNumber == "P3" {
-				val := strings.Fields(text)
-				for i := 0; i < ppm.width; i++ {
-					r, _ := strconv.ParseUint(val[i*3], 10, 8)
-					g, _ := strconv.ParseUint(val[i*3+1], 10, 8)
-					b, _ := strconv.ParseUint(val[i*3+2], 10, 8)
-					ppm.data[line][i] = Pixel{R: uint8(r), G: uint8(g), B: uint8(b)}
-				}
-				line++
-			} else if ppm.magicNumber == "P6" {
-				pixelData := make([]byte, ppm.width*ppm.height*3)
-				fileContent, err := os.ReadFile(filename)
-				if err != nil {
-					return nil, fmt.Errorf("couldn't read file: %v", err)
-				}
-				copy(pixelData, fileContent[len(fileContent)-(ppm.width*ppm.height*3):])
-				pixelIndex := 0
-				for y := 0; y < ppm.height; y++ {
-					for x := 0; x < ppm.width; x++ {
-						ppm.data[y][x].R = pixelData[pixelIndex]
-						ppm.data[y][x].G = pixelData[pixelIndex+1]
-						ppm.data[y][x].B = pixelData[pixelIndex+2]
-						pixelIndex += 3
-					}
-				}
-				break
-			}
-		}
+func newPPM(width, height int, maxValue uint16, s samples) *PPM {
+	return &PPM{
+		Image: Image[Pixel]{
+			store: rgbStore{s},
+			rect:  image.Rect(0, 0, width, height),
+		},
+		magicNumber: "P6",
+		max:         maxValue,
 	}
-
-	return ppm, nil
 }
 
-func (ppm *PPM) Save(filename string) error {
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+// rgbStore keeps three samples per pixel.
+type rgbStore struct {
+	samples
+}
 
-	writer := bufio.NewWriter(file)
-	fmt.Fprint(writer, ppm.magicNumber+"\n")
-	fmt.Fprintf(writer, "%d %d\n", ppm.width, ppm.height)
-	fmt.Fprintf(writer, "%d\n", ppm.max)
-	writer.Flush()
-
-	if ppm.magicNumber == "P3" {
-		for y, row := range ppm.data {
-			for i, pixel := range row {
-				xtra := " "
-				if i == len(row)-1 {
-					xtra = ""
-				}
-				fmt.Fprintf(writer, "%d %d %d%s", pixel.R, pixel.G, pixel.B, xtra)
-			}
-			if y != len(ppm.data)-1 {
-				fmt.Fprintln(writer, "")
-			}
-		}
-		writer.Flush()
-	} else if ppm.magicNumber == "P6" {
-		for _, row := range ppm.data {
-			for _, pixel := range row {
-				_, err = file.Write([]byte{pixel.R, pixel.G, pixel.B})
-				if err != nil {
-					return fmt.Errorf("error writing pixel data: %v", err)
-				}
-			}
-		}
+func (s rgbStore) at(x, y int) Pixel {
+	row := s.pix[y*s.stride:]
+	return Pixel{
+		R: getSample(row, x*3, s.size),
+		G: getSample(row, x*3+1, s.size),
+		B: getSample(row, x*3+2, s.size),
 	}
-
-	return nil
 }
 
-func (ppm *PPM) Size() (int, int) {
-	return ppm.width, ppm.height
+func (s rgbStore) set(x, y int, value Pixel) {
+	row := s.pix[y*s.stride:]
+	putSample(row, x*3, s.size, value.R)
+	putSample(row, x*3+1, s.size, value.G)
+	putSample(row, x*3+2, s.size, value.B)
 }
 
-func (ppm *PPM) At(x, y int) Pixel {
-	return ppm.data[y][x]
+func (s rgbStore) sub(x, y int) store[Pixel] {
+	return rgbStore{s.subSamples(x, y)}
 }
 
-func (ppm *PPM) Set(x, y int, value Pixel) {
-	ppm.data[y][x] = value
+func (s rgbStore) make(width, height int) store[Pixel] {
+	return rgbStore{newSamples(width, height, 3, s.size)}
 }
 
-func (ppm *PPM) Invert() {
-	for y, row := range ppm.data {
-		for x := range row {
-			ppm.data[y][x].R = 255 - ppm.data[y][x].R
-			ppm.data[y][x].G = 255 - ppm.data[y][x].G
-			ppm.data[y][x].B = 255 - ppm.data[y][x].B
-		}
+func NewPPMFromImage(img image.Image) *PPM {
+	bounds := img.Bounds()
+	var maxValue uint16 = 255
+	if is16BitModel(img.ColorModel()) {
+		maxValue = 65535
 	}
-}
-
-func (ppm *PPM) Flip() {
-	for _, row := range ppm.data {
-		cursor := ppm.width - 1
-
-		for x := 0; x < ppm.width/2; x++ {
-			row[x], row[cursor] = row[cursor], row[x]
-			cursor--
+	ppm := NewPPM(bounds.Dx(), bounds.Dy(), maxValue)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			ppm.Set(x, y, Pixel{
+				R: scaleSample(uint16(r), 65535, maxValue),
+				G: scaleSample(uint16(g), 65535, maxValue),
+				B: scaleSample(uint16(b), 65535, maxValue),
+			})
 		}
 	}
+	return ppm
 }
 
-func (ppm *PPM) Flop() {
-	cursor := ppm.height - 1
-	for y := 0; y < ppm.height/2; y++ {
-		ppm.data[y], ppm.data[cursor] = ppm.data[cursor], ppm.data[y]
-		cursor--
+func ReadPPM(filename string) (*PPM, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
 	}
-}
+	defer file.Close()
 
-func (ppm *PPM) SetMagicNumber(magicNumber string) {
-	ppm.magicNumber = magicNumber
+	return DecodePPM(file)
 }
 
-func (ppm *PPM) SetMaxValue(maxValue uint8) {
-	for y := range ppm.data {
-		for x := range ppm.data[y] {
-			pixel := ppm.data[y][x]
-			pixel.R = uint8(float64(pixel.R) * float64(maxValue) / float64(ppm.max))
-			pixel.G = uint8(float64(pixel.G) * float64(maxValue) / float64(ppm.max))
-			pixel.B = uint8(float64(pixel.B) * float64(maxValue) / float64(ppm.max))
-			ppm.data[y][x] = pixel
-		}
-	}
-	ppm.max = maxValue
+func DecodePPM(r io.Reader) (*PPM, error) {
+	return decodePPM(newTokenReader(r, "PPM"), nil)
 }
 
-func (ppm *PPM) Rotate90CW() {
-	rotatedData := make([][]Pixel, ppm.width)
-	for i := range rotatedData {
-		rotatedData[i] = make([]Pixel, ppm.height)
+func decodePPM(tr *tokenReader, opts *DecodeOptions) (*PPM, error) {
+	header, err := readHeader(tr)
+	if err != nil {
+		return nil, err
 	}
-	for i := 0; i < ppm.width; i++ {
-		for j := 0; j < ppm.height; j++ {
-			rotatedData[i][j] = ppm.data[ppm.height-1-j][i]
-		}
+	magicNumber, width, height, maxValue := header.MagicNumber, header.Width, header.Height, header.MaxValue
+	if magicNumber != "P3" && magicNumber != "P6" {
+		return nil, tr.errorf(ErrBadMagic, "invalid PPM magic number %q", magicNumber)
 	}
-	ppm.width, ppm.height = ppm.height, ppm.width
-	ppm.data = rotatedData
-}
-
-func (ppm *PPM) ToPBM() *PBM {
-	pbm := &PBM{
-		magicNumber: "P1",
-		height:      ppm.height,
-		width:       ppm.width,
+	rasterSize := int64(width) * int64(height) * 3
+	if magicNumber == "P6" {
+		rasterSize *= int64(bytesPerSample(maxValue))
 	}
-	for y := range ppm.data {
-		pbm.data = append(pbm.data, []bool{})
-		for x := range ppm.data[y] {
-			r, g, b := ppm.data[y][x].R, ppm.data[y][x].G, ppm.data[y][x].B
-			isBlack := (uint8((int(r)+int(g)+int(b))/3) < ppm.max/2)
-			pbm.data[y] = append(pbm.data[y], isBlack)
-		}
+	if err := opts.checkLimits(tr, width, height, rasterSize); err != nil {
+		return nil, err
 	}
-	return pbm
-}
 
-func (ppm *PPM) ToPGM() *PGM {
-	pgm := &PGM{
-		magicNumber: "P2",
-		height:      ppm.height,
-		width:       ppm.width,
-		max:         ppm.max,
-	}
-	for y := range ppm.data {
-		pgm.data = append(pgm.data, []uint8{})
-		for x := range ppm.data[y] {
-			r, g, b := ppm.data[y][x].R, ppm.data[y][x].G, ppm.data[y][x].B
-			grayValue := uint8((int(r) + int(g) + int(b)) / 3)
-			pgm.data[y] = append(pgm.data[y], grayValue)
+	size := bytesPerSample(maxValue)
+	var pix []uint8
+	if magicNumber == "P3" {
+		for i := 0; i < width*height*3; i++ {
+			value, err := tr.sample(maxValue)
+			if err != nil {
+				return nil, err
+			}
+			pix = appendSample(pix, size, value)
+		}
+	} else {
+		tr.startRaw()
+		if pix, err = tr.raster(width * height * 3 * size); err != nil {
+			return nil, err
+		}
+		if err := tr.checkSamples(pix, maxValue); err != nil {
+			return nil, err
 		}
 	}
-	return pgm
-}
 
-type Point struct {
-	X, Y int
+	ppm := newPPM(width, height, maxValue, samplesOf(pix, width, 3, size))
+	ppm.magicNumber = magicNumber
+	ppm.lines = header.Comments
+	return ppm, nil
 }
 
-func abs(x int) int {
-	if x < 0 {
-		return -x
-	}
-	return x
+func (ppm *PPM) Save(filename string) error {
+	return createAndEncode(filename, func(w io.Writer) error {
+		return EncodePPM(w, ppm)
+	})
 }
 
-func sign(x int) int {
-	if x > 0 {
-		return 1
-	} else if x < 0 {
-		return -1
-	}
-	return 0
+func (ppm *PPM) Encode(w io.Writer) error {
+	return EncodePPM(w, ppm)
 }
 
-func isWithinBounds(x, y, width, height int) bool {
-	return x >= 0 && x < width && y >= 0 && y < height
+func EncodePPM(w io.Writer, ppm *PPM) error {
+	return encodePPM(w, ppm, nil)
 }
 
-func (ppm *PPM) DrawLine(p1, p2 Point, color Pixel) {
-	deltaX := abs(p2.X - p1.X)
-	deltaY := abs(p2.Y - p1.Y)
-	sx, sy := sign(p2.X-p1.X), sign(p2.Y-p1.Y)
-	err := deltaX - deltaY
-
-	for {
-		if isWithinBounds(p1.X, p1.Y, ppm.width, ppm.height) {
-			ppm.data[p1.Y][p1.X] = color
-		}
-
-		if p1.X == p2.X && p1.Y == p2.Y {
-			break
-		}
-
-		e2 := 2 * err
+func encodePPM(w io.Writer, ppm *PPM, opts *EncodeOptions) error {
+	if ppm.magicNumber != "P3" && ppm.magicNumber != "P6" {
+		return fmt.Errorf("invalid PPM magic number %q", ppm.magicNumber)
+	}
+	if ppm.max == 0 {
+		return fmt.Errorf("invalid PPM max value %d", ppm.max)
+	}
+	if err := opts.check(); err != nil {
+		return err
+	}
+	magicNumber := opts.magicNumber(ppm.magicNumber)
 
-		if e2 > -deltaY {
-			err -= deltaY
-			p1.X += sx
+	writer := bufio.NewWriter(w)
+	opts.writeHeader(writer, magicNumber, ppm.lines)
+	width, height := ppm.Size()
+	fmt.Fprintf(writer, "%d %d\n", width, height)
+	fmt.Fprintf(writer, "%d\n", ppm.max)
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing header: %w", err)
+	}
+
+	if magicNumber == "P3" {
+		plain := opts.plainWriter(writer, " ")
+		for y := ppm.rect.Min.Y; y < ppm.rect.Max.Y; y++ {
+			for x := ppm.rect.Min.X; x < ppm.rect.Max.X; x++ {
+				pixel := ppm.PixelAt(x, y)
+				plain.write(strconv.Itoa(int(pixel.R)))
+				plain.write(strconv.Itoa(int(pixel.G)))
+				plain.write(strconv.Itoa(int(pixel.B)))
+			}
+			plain.endRow()
 		}
-
-		if e2 < deltaX {
-			err += deltaX
-			p1.Y += sy
+	} else {
+		for y := ppm.rect.Min.Y; y < ppm.rect.Max.Y; y++ {
+			if _, err := writer.Write(ppm.Row(y)); err != nil {
+				return fmt.Errorf("error writing pixel data: %w", err)
+			}
 		}
 	}
-}
-
-func (ppm *PPM) DrawRectangle(p1 Point, width, height int, color Pixel) {
-	p2 := Point{p1.X + width, p1.Y}
-	p3 := Point{p1.X, p1.Y + height}
-	p4 := Point{p1.X + width, p1.Y + height}
 
-	ppm.DrawLine(p1, p2, color)
-	ppm.DrawLine(p2, p4, color)
-	ppm.DrawLine(p4, p3, color)
-	ppm.DrawLine(p3, p1, color)
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing pixel data: %w", err)
+	}
+	return nil
 }
 
-func (ppm *PPM) DrawFilledRectangle(p1 Point, width, height int, color Pixel) {
-	p2 := Point{p1.X + width, p1.Y}
-
-	for i := 0; i <= height; i++ {
-		ppm.DrawLine(p1, p2, color)
-		p1.Y++
-		p2.Y++
+func (ppm *PPM) ColorModel() color.Model {
+	if ppm.max > 255 {
+		return color.RGBA64Model
 	}
+	return color.RGBAModel
 }
 
-func (ppm *PPM) DrawCircle(center Point, radius int, color Pixel) {
-	for y := 0; y < ppm.height; y++ {
-		for x := 0; x < ppm.width; x++ {
-			dx := float64(x - center.X)
-			dy := float64(y - center.Y)
-			distance := math.Sqrt(dx*dx + dy*dy)
-
-			if math.Abs(distance-float64(radius)*0.85) < 0.5 {
-				ppm.data[y][x] = color
-			}
+func (ppm *PPM) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(ppm.Bounds())) {
+		return color.RGBA{}
+	}
+	pixel := ppm.PixelAt(x, y)
+	if ppm.max > 255 {
+		return color.RGBA64{
+			R: scaleSample(pixel.R, ppm.max, 65535),
+			G: scaleSample(pixel.G, ppm.max, 65535),
+			B: scaleSample(pixel.B, ppm.max, 65535),
+			A: 65535,
 		}
 	}
-}
-
-func (ppm *PPM) DrawFilledCircle(center Point, radius int, color Pixel) {
-	for radius >= 0 {
-		ppm.DrawCircle(center, radius, color)
-		radius--
+	return color.RGBA{
+		R: uint8(scaleSample(pixel.R, ppm.max, 255)),
+		G: uint8(scaleSample(pixel.G, ppm.max, 255)),
+		B: uint8(scaleSample(pixel.B, ppm.max, 255)),
+		A: 255,
 	}
 }
 
-func (ppm *PPM) DrawTriangle(p1, p2, p3 Point, color Pixel) {
-	ppm.DrawLine(p1, p2, color)
-	ppm.DrawLine(p2, p3, color)
-	ppm.DrawLine(p3, p1, color)
+// SubImage returns a view of the part of ppm inside r. The view shares
+// ppm's samples, so Set, Invert, Flip, Flop and the Draw methods on it
+// change ppm as well. SetMaxValue, Rotate90CW and KNearestNeighbors give
+// the view samples of its own.
+func (ppm *PPM) SubImage(r image.Rectangle) *PPM {
+	return ppm.with(ppm.Image.SubImage(r))
 }
 
-func (ppm *PPM) DrawFilledTriangle(p1, p2, p3 Point, color Pixel) {
-	for p1 != p2 {
-		ppm.DrawLine(p3, p1, color)
-
-		if p1.X != p2.X {
-			p1.X += sign(p2.X - p1.X)
-		}
-
-		if p1.Y != p2.Y {
-			p1.Y += sign(p2.Y - p1.Y)
-		}
-	}
-
-	ppm.DrawLine(p3, p1, color)
+// Crop returns a copy of the part of ppm inside r, with its origin at (0, 0).
+func (ppm *PPM) Crop(r image.Rectangle) *PPM {
+	return ppm.with(ppm.Image.Crop(r))
 }
 
-func (ppm *PPM) DrawPolygon(points []Point, color Pixel) {
-	for i := 0; i < len(points)-1; i++ {
-		ppm.DrawLine(points[i], points[i+1], color)
-	}
-
-	ppm.DrawLine(points[len(points)-1], points[0], color)
+func (ppm *PPM) Clone() PNM {
+	return ppm.with(ppm.Image.Clone())
 }
 
-func (ppm *PPM) DrawFilledPolygon(points []Point, color Pixel) {
-	if len(points) < 3 {
-		return
-	}
-
-	minY, maxY := boundingBoxY(points)
-
-	for y := minY; y <= maxY; y++ {
-		intersections := findIntersections(points, y)
-
-		sort.Sort(intersectionSlice(intersections))
-
-		for i := 0; i < len(intersections); i += 2 {
-			x1 := intersections[i]
-			x2 := intersections[i+1]
-
-			for x := x1; x <= x2; x++ {
-				ppm.data[y][x] = color
-			}
-		}
+// with returns a PPM holding img with ppm's magic number, max value and
+// comments.
+func (ppm *PPM) with(img *Image[Pixel]) *PPM {
+	return &PPM{
+		Image:       *img,
+		magicNumber: ppm.magicNumber,
+		max:         ppm.max,
+		comments:    comments{lines: ppm.Comments()},
 	}
 }
 
-func boundingBoxY(points []Point) (minY, maxY int) {
-	if len(points) == 0 {
-		return 0, 0
-	}
-
-	minY, maxY = points[0].Y, points[0].Y
-	for _, p := range points {
-		if p.Y < minY {
-			minY = p.Y
-		}
-		if p.Y > maxY {
-			maxY = p.Y
-		}
-	}
-	return minY, maxY
+// Row returns the encoded samples of row y, backed by the image's memory.
+func (ppm *PPM) Row(y int) []uint8 {
+	return ppm.store.(rgbStore).row(y-ppm.rect.Min.Y, ppm.rect.Dx())
 }
 
-func boundingBox(points []Point) (int, int, int, int) {
-	minX, minY, maxX, maxY := points[0].X, points[0].Y, points[0].X, points[0].Y
-
-	for _, point := range points {
-		if point.X < minX {
-			minX = point.X
-		}
-		if point.X > maxX {
-			maxX = point.X
-		}
-		if point.Y < minY {
-			minY = point.Y
-		}
-		if point.Y > maxY {
-			maxY = point.Y
-		}
-	}
-
-	return minX, minY, maxX, maxY
+func (ppm *PPM) Stride() int {
+	return ppm.store.(rgbStore).stride
 }
 
-func findIntersections(points []Point, y int) []int {
-	intersections := make([]int, 0)
-
-	for i := 0; i < len(points); i++ {
-		j := (i + 1) % len(points)
-
-		y1, y2 := points[i].Y, points[j].Y
+func (ppm *PPM) PixelAt(x, y int) Pixel {
+	return ppm.Get(x, y)
+}
 
-		if (y1 <= y && y < y2) || (y2 <= y && y < y1) {
-			x := int(float64(y-y1)*(float64(points[j].X-points[i].X)/float64(y2-y1))) + points[i].X
-			intersections = append(intersections, x)
+func (ppm *PPM) Invert() {
+	ppm.Map(func(pixel Pixel) Pixel {
+		return Pixel{
+			R: invertSample(pixel.R, ppm.max),
+			G: invertSample(pixel.G, ppm.max),
+			B: invertSample(pixel.B, ppm.max),
 		}
-	}
-
-	return intersections
+	})
 }
 
-type intersectionSlice []int
-
-func (s intersectionSlice) Len() int           { return len(s) }
-func (s intersectionSlice) Less(i, j int) bool { return s[i] < s[j] }
-func (s intersectionSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
-
-func (ppm *PPM) DrawKochSnowflake(n int, start Point, width int, color Pixel) {
-	var drawKoch func(level int, p1, p2 Point)
+func (ppm *PPM) MagicNumber() string {
+	return ppm.magicNumber
+}
 
-	p1 := start
-	p2 := Point{X: start.X + width, Y: start.Y}
+func (ppm *PPM) SetMagicNumber(magicNumber string) {
+	ppm.magicNumber = magicNumber
+}
 
-	drawKoch = func(level int, p1, p2 Point) {
-		if level == 0 {
-			ppm.DrawLine(p1, p2, color)
-		} else {
-			dx := p2.X - p1.X
-			dy := p2.Y - p1.Y
+// SetMaxValue rescales the samples to maxValue, which must be at least 1.
+func (ppm *PPM) SetMaxValue(maxValue uint16) error {
+	if maxValue == 0 {
+		return fmt.Errorf("invalid PPM max value %d", maxValue)
+	}
+	width, height := ppm.Size()
+	scaled := rgbStore{newSamples(width, height, 3, bytesPerSample(maxValue))}
+	ppm.Each(func(x, y int, pixel Pixel) {
+		pixel.R = scaleSample(pixel.R, ppm.max, maxValue)
+		pixel.G = scaleSample(pixel.G, ppm.max, maxValue)
+		pixel.B = scaleSample(pixel.B, ppm.max, maxValue)
+		scaled.set(x-ppm.rect.Min.X, y-ppm.rect.Min.Y, pixel)
+	})
+	ppm.store, ppm.max = scaled, maxValue
+	return nil
+}
 
-			p1_3 := Point{X: p1.X + dx/3, Y: p1.Y + dy/3}
-			p2_3 := Point{X: p1.X + 2*dx/3, Y: p1.Y + 2*dy/3}
+func (ppm *PPM) ToPBM() *PBM {
+	return (*ConvertOptions)(nil).ToPBM(ppm)
+}
 
-			angle := math.Pi / 3.0
-			cosAngle := math.Cos(angle)
-			sinAngle := math.Sin(angle)
-			pTriangle := Point{
-				X: int(float64(p1_3.X-p2_3.X)*cosAngle-float64(p1_3.Y-p2_3.Y)*sinAngle) + p2_3.X,
-				Y: int(float64(p1_3.X-p2_3.X)*sinAngle+float64(p1_3.Y-p2_3.Y)*cosAngle) + p2_3.Y,
-			}
+func (ppm *PPM) ToPGM() *PGM {
+	return (*ConvertOptions)(nil).ToPGM(ppm)
+}
 
-			drawKoch(level-1, p1, p1_3)
-			drawKoch(level-1, p1_3, pTriangle)
-			drawKoch(level-1, pTriangle, p2_3)
-			drawKoch(level-1, p2_3, p2)
+func (ppm *PPM) ToPAM() *PAM {
+	width, height := ppm.Size()
+	pam := newPAM(width, height, 3, TupleTypeRGB, ppm.max)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pixel := ppm.PixelAt(ppm.rect.Min.X+x, ppm.rect.Min.Y+y)
+			pam.SetTuple(x, y, []uint16{pixel.R, pixel.G, pixel.B})
 		}
 	}
-
-	drawKoch(n, p1, p2)
+	return pam
 }
 
-func (ppm *PPM) DrawSierpinskiTriangle(n int, start Point, width int, color Pixel) {
-	var drawSierpinski func(level int, p1, p2, p3 Point)
-
-	height := int(float64(width) * math.Sqrt(3) / 2)
-	p1 := start
-	p2 := Point{X: start.X + width, Y: start.Y}
-	p3 := Point{X: start.X + width/2, Y: start.Y + height}
-
-	drawSierpinski = func(level int, p1, p2, p3 Point) {
-		if level == 0 {
-			ppm.DrawFilledTriangle(p1, p2, p3, color)
-		} else {
-			pMiddle1 := Point{X: (p1.X + p2.X) / 2, Y: (p1.Y + p2.Y) / 2}
-			pMiddle2 := Point{X: (p2.X + p3.X) / 2, Y: (p2.Y + p3.Y) / 2}
-			pMiddle3 := Point{X: (p3.X + p1.X) / 2, Y: (p3.Y + p1.Y) / 2}
-
-			drawSierpinski(level-1, p1, pMiddle1, pMiddle3)
-			drawSierpinski(level-1, pMiddle1, p2, pMiddle2)
-			drawSierpinski(level-1, pMiddle3, pMiddle2, p3)
+func (ppm *PPM) ToPFM() *PFM {
+	width, height := ppm.Size()
+	pfm := newPFM(width, height, 3)
+	max := float32(ppm.max)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pixel := ppm.PixelAt(ppm.rect.Min.X+x, ppm.rect.Min.Y+y)
+			pfm.SetFloat(x, y, float32(pixel.R)/max, float32(pixel.G)/max, float32(pixel.B)/max)
 		}
 	}
-
-	drawSierpinski(n, p1, p2, p3)
+	return pfm
 }
 
 func (ppm *PPM) DrawPerlinNoise(color1 Pixel, color2 Pixel) {
@@ -505,20 +346,20 @@ func (ppm *PPM) DrawPerlinNoise(color1 Pixel, color2 Pixel) {
 	octaves := 4
 	persistence := 0.5
 
-	for y := 0; y < ppm.height; y++ {
-		for x := 0; x < ppm.width; x++ {
+	for y := ppm.rect.Min.Y; y < ppm.rect.Max.Y; y++ {
+		for x := ppm.rect.Min.X; x < ppm.rect.Max.X; x++ {
 			perlinValue := perlinNoise(float64(x)*scale, float64(y)*scale, octaves, persistence)
 
 			lerpColor := lerpColor(color1, color2, perlinValue)
 
-			ppm.data[y][x] = lerpColor
+			ppm.Set(x, y, lerpColor)
 		}
 	}
 }
 
 func lerpColor(color1 Pixel, color2 Pixel, t float64) Pixel {
-	lerpComponent := func(c1, c2 uint8, t float64) uint8 {
-		return uint8(float64(c1)*(1.0-t) + float64(c2)*t)
+	lerpComponent := func(c1, c2 uint16, t float64) uint16 {
+		return uint16(float64(c1)*(1.0-t) + float64(c2)*t)
 	}
 
 	return Pixel{
@@ -550,42 +391,3 @@ func noise(x, y float64) float64 {
 	n = (n << 13) ^ n
 	return (1.0 - float64((n*(n*n*15731+789221)+1376312589)&0x7fffffff)/1073741824.0)
 }
-
-func (ppm *PPM) KNearestNeighbors(newWidth, newHeight int) {
-	scaleX := float64(ppm.width) / float64(newWidth)
-	scaleY := float64(ppm.height) / float64(newHeight)
-
-	resizedData := make([][]Pixel, newHeight)
-	for i := range resizedData {
-		resizedData[i] = make([]Pixel, newWidth)
-	}
-
-	for y := 0; y < newHeight; y++ {
-		for x := 0; x < newWidth; x++ {
-			originalX := int(float64(x) * scaleX)
-			originalY := int(float64(y) * scaleY)
-
-			resizedData[y][x] = ppm.getNearestNeighbor(originalX, originalY)
-		}
-	}
-
-	ppm.width = newWidth
-	ppm.height = newHeight
-	ppm.data = resizedData
-}
-
-func (ppm *PPM) getNearestNeighbor(x, y int) Pixel {
-	x = clamp(x, 0, ppm.width-1)
-	y = clamp(y, 0, ppm.height-1)
-
-	return ppm.data[y][x]
-}
-
-func clamp(value, min, max int) int {
-	if value < min {
-		return min
-	} else if value > max {
-		return max
-	}
-	return value
-}
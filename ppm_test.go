@@ -0,0 +1,163 @@
+package Netpbm
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+)
+
+// gradientPPM returns a width x height image whose channels vary along
+// both axes.
+func gradientPPM(width, height int, maxValue uint16) *PPM {
+	img := image.NewNRGBA64(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA64(x, y, color.NRGBA64{
+				R: uint16(x * 65535 / width),
+				G: uint16(y * 65535 / height),
+				B: uint16((x + y) * 65535 / (width + height)),
+				A: 65535,
+			})
+		}
+	}
+	ppm := NewPPMFromImage(img)
+	ppm.SetMaxValue(maxValue)
+	return ppm
+}
+
+// rampPPM returns a one-row PPM whose channels run through the values of
+// rampPGM in different orders.
+func rampPPM(maxValue uint16) *PPM {
+	ramp := rampPGM(maxValue)
+	width, _ := ramp.Size()
+	ppm := NewPPM(width, 1, maxValue)
+	ramp.Each(func(x, y int, value uint16) {
+		ppm.Set(x, y, Pixel{R: value, G: maxValue - value, B: ramp.GrayAt(width-1-x, y) / 2})
+	})
+	return ppm
+}
+
+func TestPPMSetMaxValue(t *testing.T) {
+	for _, from := range testMaxValues {
+		for _, to := range testMaxValues {
+			t.Run(fmt.Sprintf("%d-%d", from, to), func(t *testing.T) {
+				original := rampPPM(from)
+				ppm := original.Clone().(*PPM)
+				if err := ppm.SetMaxValue(to); err != nil {
+					t.Fatal(err)
+				}
+				original.Each(func(x, y int, pixel Pixel) {
+					got := ppm.PixelAt(x, y)
+					if !isNearest(pixel.R, from, got.R, to) || !isNearest(pixel.G, from, got.G, to) || !isNearest(pixel.B, from, got.B, to) {
+						t.Fatalf("%v became %v", pixel, got)
+					}
+				})
+
+				if to < from {
+					return
+				}
+				if err := ppm.SetMaxValue(from); err != nil {
+					t.Fatal(err)
+				}
+				original.Each(func(x, y int, pixel Pixel) {
+					if got := ppm.PixelAt(x, y); got != pixel {
+						t.Fatalf("%v came back as %v", pixel, got)
+					}
+				})
+			})
+		}
+	}
+}
+
+func TestPPMMaxValueZero(t *testing.T) {
+	ppm := rampPPM(255)
+	if err := ppm.SetMaxValue(0); err == nil {
+		t.Fatal("SetMaxValue(0) succeeded")
+	}
+	if ppm.max != 255 {
+		t.Fatalf("max value changed to %d", ppm.max)
+	}
+	if err := NewPPM(2, 2, 0).Encode(io.Discard); err == nil {
+		t.Fatal("encoded an image with max value 0")
+	}
+}
+
+func TestPPMEncodeRoundTrip(t *testing.T) {
+	for _, maxValue := range testMaxValues {
+		for _, magicNumber := range []string{"P3", "P6"} {
+			t.Run(fmt.Sprintf("%s-%d", magicNumber, maxValue), func(t *testing.T) {
+				ppm := rampPPM(maxValue)
+				ppm.SetMagicNumber(magicNumber)
+				var buf bytes.Buffer
+				if err := ppm.Encode(&buf); err != nil {
+					t.Fatal(err)
+				}
+				decoded, err := DecodePPM(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if decoded.MagicNumber() != magicNumber || decoded.max != maxValue {
+					t.Fatalf("got %s with max value %d", decoded.MagicNumber(), decoded.max)
+				}
+				ppm.Each(func(x, y int, pixel Pixel) {
+					if got := decoded.PixelAt(x, y); got != pixel {
+						t.Fatalf("pixel %d: got %v, want %v", x, got, pixel)
+					}
+				})
+			})
+		}
+	}
+}
+
+func TestPPMInvert(t *testing.T) {
+	for _, maxValue := range testMaxValues {
+		t.Run(fmt.Sprint(maxValue), func(t *testing.T) {
+			original := rampPPM(maxValue)
+			ppm := original.Clone().(*PPM)
+			ppm.Invert()
+			original.Each(func(x, y int, pixel Pixel) {
+				want := Pixel{R: maxValue - pixel.R, G: maxValue - pixel.G, B: maxValue - pixel.B}
+				if got := ppm.PixelAt(x, y); got != want {
+					t.Fatalf("%v inverted to %v", pixel, got)
+				}
+			})
+		})
+	}
+}
+
+func TestPPMToPBMAndPGM(t *testing.T) {
+	for _, maxValue := range testMaxValues {
+		t.Run(fmt.Sprint(maxValue), func(t *testing.T) {
+			ramp := rampPGM(maxValue)
+			width, _ := ramp.Size()
+			ppm := NewPPM(width, 1, maxValue)
+			ramp.Each(func(x, y int, value uint16) {
+				ppm.Set(x, y, Pixel{R: value, G: value, B: value})
+			})
+			pbm, pgm := ppm.ToPBM(), ppm.ToPGM()
+			ramp.Each(func(x, y int, value uint16) {
+				if got := pgm.GrayAt(x, y); got != value {
+					t.Fatalf("gray %d became %d", value, got)
+				}
+				if want := 2*uint32(value) < uint32(maxValue); pbm.BitAt(x, y) != want {
+					t.Fatalf("%d: got black %v, want %v", value, pbm.BitAt(x, y), want)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkEncodePPM(b *testing.B) {
+	ppm := gradientPPM(2000, 1500, 255)
+	ppm.SetMagicNumber("P6")
+	b.SetBytes(2000 * 1500 * 3)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := EncodePPM(io.Discard, ppm); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package Netpbm
+
+import (
+	"bufio"
+	"io"
+)
+
+// Decoder reads the successive images of a multi-image Netpbm stream.
+type Decoder struct {
+	br   *bufio.Reader
+	opts *DecodeOptions
+}
+
+func NewDecoder(r io.Reader, opts *DecodeOptions) *Decoder {
+	return &Decoder{br: newReader(r), opts: opts}
+}
+
+// Next decodes the next image in the stream. It returns io.EOF once the
+// stream holds nothing but trailing whitespace.
+func (d *Decoder) Next() (PNM, error) {
+	for {
+		next, err := d.br.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if !isSpace(next[0]) {
+			break
+		}
+		d.br.ReadByte()
+	}
+	return d.opts.Decode(d.br)
+}
+
+// Encoder writes images one after another to a single stream.
+type Encoder struct {
+	w    io.Writer
+	opts *EncodeOptions
+}
+
+func NewEncoder(w io.Writer, opts *EncodeOptions) *Encoder {
+	return &Encoder{w: w, opts: opts}
+}
+
+func (e *Encoder) Encode(img PNM) error {
+	return e.opts.Encode(e.w, img)
+}
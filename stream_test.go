@@ -0,0 +1,68 @@
+package Netpbm
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestStreamRoundTrip(t *testing.T) {
+	pbm := NewPBM(70, 3)
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 70; x++ {
+			pbm.Set(x, y, (x*7+y)%3 == 0)
+		}
+	}
+
+	// Each image is followed by its separator; the raw ones with no
+	// separator run straight into the next magic number.
+	images := []struct {
+		magicNumber string
+		img         PNM
+		separator   string
+	}{
+		{"P1", pbm.Clone(), "\n"},
+		{"P4", pbm.Clone(), ""},
+		{"P2", rampPGM(1000), " \t\n"},
+		{"P5", rampPGM(255), ""},
+		{"P5", rampPGM(4095), "\n\n"},
+		{"P3", rampPPM(255), "\r\n"},
+		{"P6", rampPPM(65535), ""},
+		{"P6", rampPPM(15), "\n"},
+	}
+
+	var buf bytes.Buffer
+	encoder := NewEncoder(&buf, nil)
+	for _, image := range images {
+		image.img.SetMagicNumber(image.magicNumber)
+		if err := encoder.Encode(image.img); err != nil {
+			t.Fatal(err)
+		}
+		buf.WriteString(image.separator)
+	}
+
+	decoder := NewDecoder(&buf, nil)
+	for i, image := range images {
+		decoded, err := decoder.Next()
+		if err != nil {
+			t.Fatalf("image %d: %v", i, err)
+		}
+		if decoded.MagicNumber() != image.magicNumber {
+			t.Fatalf("image %d: got %s, want %s", i, decoded.MagicNumber(), image.magicNumber)
+		}
+		if decoded.Bounds() != image.img.Bounds() {
+			t.Fatalf("image %d: got bounds %v, want %v", i, decoded.Bounds(), image.img.Bounds())
+		}
+		bounds := decoded.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if got, want := decoded.At(x, y), image.img.At(x, y); got != want {
+					t.Fatalf("image %d, pixel (%d, %d): got %v, want %v", i, x, y, got, want)
+				}
+			}
+		}
+	}
+	if _, err := decoder.Next(); err != io.EOF {
+		t.Errorf("got %v after the last image, want io.EOF", err)
+	}
+}